@@ -1,29 +1,12 @@
 package erasurecode
 
-/*
-#cgo pkg-config: erasurecode-1
-#include <stdlib.h>
-#include <liberasurecode/erasurecode.h>
-#include <liberasurecode/erasurecode_helpers_ext.h>
-// shims to make working with frag arrays easier
-char ** makeStrArray(int n) { return calloc(n, sizeof (char *)); }
-void freeStrArray(char ** arr) { free(arr); }
-void * getStrArrayItem(char ** arr, int idx) { return arr[idx]; }
-void setStrArrayItem(char ** arr, int idx, unsigned char * val) { arr[idx] = (char *) val; }
-// shims because the fragment headers use misaligned fields
-uint64_t getOrigDataSize(struct fragment_header_s *header) { return header->meta.orig_data_size; }
-uint32_t getBackendVersion(struct fragment_header_s *header) { return header->meta.backend_version; }
-ec_backend_id_t getBackendID(struct fragment_header_s *header) { return header->meta.backend_id; }
-uint32_t getECVersion(struct fragment_header_s *header) { return header->libec_version; }
-uint32_t getMetadataChksum(struct fragment_header_s *header) { return header->metadata_chksum; }
-*/
-import "C"
-
 import (
+	"context"
 	"errors"
 	"fmt"
-	"runtime"
-	"unsafe"
+	"hash/crc32"
+	"os"
+	"sync"
 )
 
 type Version struct {
@@ -47,10 +30,7 @@ func (v Version) Less(other Version) bool {
 	return false
 }
 
-func GetVersion() Version {
-	return makeVersion(C.liberasurecode_get_version())
-}
-func makeVersion(v C.uint32_t) Version {
+func makeVersion(v uint32) Version {
 	return Version{
 		Major:    uint(v>>16) & 0xffff,
 		Minor:    uint(v>>8) & 0xff,
@@ -58,6 +38,11 @@ func makeVersion(v C.uint32_t) Version {
 	}
 }
 
+// pack encodes v the way liberasurecode stores versions in fragment headers.
+func (v Version) pack() uint32 {
+	return uint32(v.Major)<<16 | uint32(v.Minor&0xff)<<8 | uint32(v.Revision&0xff)
+}
+
 var KnownBackends = [...]string{
 	"null",
 	"jerasure_rs_vand",
@@ -70,139 +55,568 @@ var KnownBackends = [...]string{
 	"libphazr",
 }
 
+// AvailableBackends lists the usable backends: those of KnownBackends that
+// are available, followed by any added with RegisterBackend.
 func AvailableBackends() (avail []string) {
 	for _, name := range KnownBackends {
 		if BackendIsAvailable(name) {
 			avail = append(avail, name)
 		}
 	}
+	for _, name := range registeredBackendNames() {
+		if BackendIsAvailable(name) {
+			avail = append(avail, name)
+		}
+	}
 	return
 }
 
+// ChecksumType identifies how a fragment's payload is checksummed. The
+// values match liberasurecode's ec_checksum_type_t, as stored in fragment
+// headers.
+type ChecksumType uint8
+
+const (
+	// ChecksumNone disables payload checksums.
+	ChecksumNone ChecksumType = 1
+	// ChecksumCRC32 stores a CRC32 of the payload in the fragment header.
+	// This is the default when Params.ChecksumType is left unset.
+	ChecksumCRC32 ChecksumType = 2
+	// ChecksumMD5 is accepted by liberasurecode, but no released version
+	// actually computes it; fragments are written without a usable checksum.
+	ChecksumMD5 ChecksumType = 3
+)
+
+func (ct ChecksumType) String() string {
+	switch ct {
+	case 0:
+		return "unset"
+	case ChecksumNone:
+		return "none"
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumMD5:
+		return "md5"
+	default:
+		return fmt.Sprintf("<unknown checksum type %d>", uint8(ct))
+	}
+}
+
+// CRCVariant selects the CRC routine metadata checksums are written with.
+type CRCVariant uint8
+
+const (
+	// CRCDefault follows liberasurecode, which writes the legacy CRC if
+	// LIBERASURECODE_WRITE_LEGACY_CRC is set to anything but "" or "0",
+	// and the standard one otherwise.
+	CRCDefault CRCVariant = iota
+	// CRCStandard writes the standard (IEEE) CRC32, whatever the
+	// environment says.
+	CRCStandard
+	// CRCLegacy writes liberasurecode's legacy CRC, which liberasurecode
+	// older than 1.6.0 requires.
+	CRCLegacy
+)
+
+func (v CRCVariant) String() string {
+	switch v {
+	case CRCDefault:
+		return "default"
+	case CRCStandard:
+		return "standard"
+	case CRCLegacy:
+		return "legacy"
+	default:
+		return fmt.Sprintf("<unknown CRC variant %d>", uint8(v))
+	}
+}
+
+// legacy reports whether v writes the legacy CRC.
+func (v CRCVariant) legacy() bool {
+	if v == CRCDefault {
+		return legacyCRCFromEnv()
+	}
+	return v == CRCLegacy
+}
+
+// legacyCRCFromEnv mimics liberasurecode's handling of
+// LIBERASURECODE_WRITE_LEGACY_CRC: any value but "" or "0" enables it.
+func legacyCRCFromEnv() bool {
+	val := os.Getenv("LIBERASURECODE_WRITE_LEGACY_CRC")
+	return val != "" && val != "0"
+}
+
 type Params struct {
 	Name string
 	K    int
 	M    int
 	W    int
 	HD   int
+	// ChecksumType selects the payload checksum written into each
+	// fragment header; zero means ChecksumCRC32.
+	ChecksumType ChecksumType
+	// CRCVariant selects the CRC routine metadata checksums are written
+	// with: CRCLegacy for clusters with nodes running liberasurecode older
+	// than 1.6.0, CRCStandard to override LIBERASURECODE_WRITE_LEGACY_CRC,
+	// or zero (CRCDefault) to honour it as liberasurecode does.
+	CRCVariant CRCVariant
+}
+
+// engine is the implementation behind a Backend: either a liberasurecode
+// instance or one of the pure-Go codes in goEngines.
+type engine interface {
+	encode(data []byte) ([][]byte, error)
+	decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error)
+	reconstruct(frags [][]byte, fragIndex int) ([]byte, error)
+	isInvalidFragment(frag []byte) bool
+	fragmentsNeeded(want, exclude []int) ([]int, error)
+	alignedDataSize(dataLen int) (int, error)
+	minimumEncodeSize() (int, error)
+	fragmentSize(dataLen int) (int, error)
+	close() error
 }
 
+// A Backend encodes and decodes fragments according to its Params. Backends
+// are implemented by liberasurecode where possible; liberasurecode_rs_vand
+// and isa_l_rs_vand fall back to wire-compatible pure-Go implementations
+// when liberasurecode (or cgo) is not available.
+//
+// A Backend may be used from several goroutines at once, and copies of it
+// share the same underlying instance. Close waits for calls already in
+// progress (on any copy) to return before releasing the instance; calls
+// made once Close has begun fail with an error wrapping ErrBackendClosed.
+// Close must not be called from within a call on the same Backend, such as
+// by a registered Coder, as it would wait forever.
 type Backend struct {
 	Params
-	libecDesc C.int
+	shared *backendState
+}
+
+// backendState is shared between copies of a Backend. refs counts the
+// calls in progress, so Close can wait for them before destroying impl;
+// drained is closed once they have all returned. (A sync.Cond would point
+// back into the state, keeping it from being finalized.)
+type backendState struct {
+	mu      sync.Mutex
+	impl    engine
+	refs    int
+	closing bool
+	drained chan struct{}
+}
+
+func newBackend(params Params, impl engine) Backend {
+	return Backend{Params: params, shared: &backendState{impl: impl}}
+}
+
+// Coder is the set of operations a Backend provides. Code that only needs
+// to encode and decode should accept a Coder rather than a *Backend, so it
+// can be handed fakes in tests or alternative implementations.
+//
+// (The method is Parameters rather than Params, as Backend embeds its
+// Params.)
+type Coder interface {
+	Encode(data []byte) ([][]byte, error)
+	Decode(frags [][]byte) ([]byte, error)
+	Reconstruct(frags [][]byte, fragIndex int) ([]byte, error)
+	IsInvalidFragment(frag []byte) bool
+	Close() error
+	Parameters() Params
 }
 
+var _ Coder = (*Backend)(nil)
+
 func BackendIsAvailable(name string) bool {
 	id, err := nameToID(name)
 	if err != nil {
 		return false
 	}
-	return C.liberasurecode_backend_available(id) != 0
+	if factory, ok := registeredBackend(name); ok && factory.New != nil {
+		return true
+	}
+	if libecBackendAvailable(id) {
+		return true
+	}
+	_, ok := goEngines[id]
+	return ok
 }
 
+// InitBackend creates a backend for params, which are checked with
+// Params.Validate first.
 func InitBackend(params Params) (Backend, error) {
-	backend := Backend{params, 0}
+	backend := Backend{Params: params}
+	if err := params.Validate(); err != nil {
+		return backend, err
+	}
 	id, err := nameToID(backend.Name)
 	if err != nil {
 		return backend, err
 	}
-	desc := C.liberasurecode_instance_create(id, &C.struct_ec_args{
-		k:  C.int(backend.K),
-		m:  C.int(backend.M),
-		w:  C.int(backend.W),
-		hd: C.int(backend.HD),
-		ct: C.CHKSUM_CRC32,
-	})
-	if desc < 0 {
-		return backend, fmt.Errorf("instance_create() returned %v", errToName(-desc))
+	var impl engine
+	if factory, ok := registeredBackend(backend.Name); ok && factory.New != nil {
+		var coder Coder
+		if coder, err = factory.New(params); err == nil {
+			impl = coderEngine{coder, params}
+		}
+	} else if newGoEngine, ok := goEngines[id]; ok && !libecBackendAvailable(id) {
+		impl, err = newGoEngine(params)
+	} else {
+		impl, err = newLibecEngine(id, params)
+	}
+	if err != nil {
+		return backend, err
 	}
-	backend.libecDesc = desc
-	return backend, nil
+	return newBackend(params, impl), nil
 }
 
-func (backend *Backend) Close() error {
-	if backend.libecDesc == 0 {
-		return errors.New("backend already closed")
+// encodeEmpty encodes a zero-length object with e. liberasurecode refuses
+// empty input, so encode a single zero byte and record an original size of
+// zero in each header; decoding then yields no data.
+func encodeEmpty(e engine) ([][]byte, error) {
+	frags, err := e.encode([]byte{0})
+	if err != nil {
+		return nil, err
 	}
-	if rc := C.liberasurecode_instance_destroy(backend.libecDesc); rc != 0 {
-		return fmt.Errorf("instance_destroy() returned %v", errToName(-rc))
+	for _, frag := range frags {
+		setOrigDataSize(frag, 0)
 	}
-	backend.libecDesc = 0
-	return nil
+	return frags, nil
 }
 
-func (backend *Backend) Encode(data []byte) ([][]byte, error) {
-	var dataFrags **C.char
-	var parityFrags **C.char
-	var fragLength C.uint64_t
-	pData := (*C.char)(unsafe.Pointer(&data[0]))
-	if rc := C.liberasurecode_encode(
-		backend.libecDesc, pData, C.uint64_t(len(data)),
-		&dataFrags, &parityFrags, &fragLength); rc != 0 {
-		return nil, fmt.Errorf("encode() returned %v", errToName(-rc))
+// acquire returns the implementation behind backend, along with a function
+// to call once done with it; Close waits until every acquired engine has
+// been released. If the backend was never initialized or is closing, the
+// engine returned fails every call.
+func (backend *Backend) acquire() (engine, func()) {
+	state := backend.shared
+	if state == nil {
+		return closedEngine{backend.Params, ErrBackendNotAvailable}, func() {}
 	}
-	defer C.liberasurecode_encode_cleanup(
-		backend.libecDesc, dataFrags, parityFrags)
-	result := make([][]byte, backend.K+backend.M)
-	for i := 0; i < backend.K; i++ {
-		result[i] = C.GoBytes(C.getStrArrayItem(dataFrags, C.int(i)), C.int(fragLength))
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.closing || state.impl == nil {
+		return closedEngine{backend.Params, ErrBackendClosed}, func() {}
 	}
-	for i := 0; i < backend.M; i++ {
-		result[i+backend.K] = C.GoBytes(C.getStrArrayItem(parityFrags, C.int(i)), C.int(fragLength))
+	state.refs++
+	return state.impl, func() {
+		state.mu.Lock()
+		state.refs--
+		if state.refs == 0 && state.drained != nil {
+			close(state.drained)
+			state.drained = nil
+		}
+		state.mu.Unlock()
 	}
-	return result, nil
 }
 
-func (backend *Backend) Decode(frags [][]byte) ([]byte, error) {
-	var data *C.char
-	var dataLength C.uint64_t
-	if len(frags) == 0 {
-		return nil, errors.New("decoding requires at least one fragment")
+// current returns the implementation behind backend, or nil if there is
+// none. Unlike acquire, it doesn't hold off Close.
+func (backend *Backend) current() engine {
+	state := backend.shared
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.impl
+}
+
+// closedEngine stands in for a missing implementation, failing each call
+// the way liberasurecode does for an unknown descriptor.
+type closedEngine struct {
+	params Params
+	cause  error // ErrBackendNotAvailable or ErrBackendClosed
+}
+
+func (e closedEngine) err(op string) error {
+	return &Error{
+		Op:      op,
+		Backend: e.params.Name,
+		Errno:   errnoEBACKENDNOTAVAIL,
+		Params:  e.params,
+		Err:     e.cause,
 	}
+}
+
+func (e closedEngine) encode([]byte) ([][]byte, error) {
+	return nil, e.err("encode")
+}
+
+func (e closedEngine) decode([][]byte, bool) ([]byte, error) {
+	return nil, e.err("decode")
+}
+
+func (e closedEngine) reconstruct([][]byte, int) ([]byte, error) {
+	return nil, e.err("reconstruct_fragment")
+}
+
+func (e closedEngine) isInvalidFragment([]byte) bool {
+	return true
+}
+
+func (e closedEngine) fragmentsNeeded([]int, []int) ([]int, error) {
+	return nil, e.err("fragments_needed")
+}
+
+func (e closedEngine) alignedDataSize(int) (int, error) {
+	return 0, e.err("get_aligned_data_size")
+}
+
+func (e closedEngine) minimumEncodeSize() (int, error) {
+	return 0, e.err("get_minimum_encode_size")
+}
+
+func (e closedEngine) fragmentSize(int) (int, error) {
+	return 0, e.err("get_fragment_size")
+}
+
+func (e closedEngine) close() error {
+	return e.err("instance_destroy")
+}
+
+// Parameters returns the Params the backend was created with.
+func (backend *Backend) Parameters() Params {
+	return backend.Params
+}
+
+// String describes the backend by its Params, in the form ParsePolicy
+// reads.
+func (backend Backend) String() string {
+	return backend.Params.String()
+}
 
-	cFrags := C.makeStrArray(C.int(len(frags)))
-	defer C.freeStrArray(cFrags)
-	for index, frag := range frags {
-		C.setStrArrayItem(cFrags, C.int(index), (*C.uchar)(&frag[0]))
+// MarshalText encodes the backend's Params, as Params.MarshalText does, so
+// a Backend can be logged or recorded alongside what it wrote.
+func (backend Backend) MarshalText() ([]byte, error) {
+	return backend.Params.MarshalText()
+}
+
+// MarshalJSON encodes the backend's Params, as Params.MarshalJSON does.
+func (backend Backend) MarshalJSON() ([]byte, error) {
+	return backend.Params.MarshalJSON()
+}
+
+// UnmarshalText fails: a Backend's Params can't change once it has been
+// created. Unmarshal a Params and pass it to InitBackend instead.
+func (backend *Backend) UnmarshalText(text []byte) error {
+	return fmt.Errorf("cannot unmarshal into a Backend; use InitBackend: %w", ErrInvalidParams)
+}
+
+// UnmarshalJSON fails, as UnmarshalText does.
+func (backend *Backend) UnmarshalJSON(data []byte) error {
+	return backend.UnmarshalText(data)
+}
+
+// Close releases the backend, once any calls in progress have returned.
+// Closing a backend (or any copy of it) a second time returns an error
+// wrapping ErrBackendClosed.
+func (backend *Backend) Close() error {
+	state := backend.shared
+	if state == nil {
+		return ErrBackendClosed
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.closing || state.impl == nil {
+		return ErrBackendClosed
 	}
+	state.closing = true
+	if state.refs > 0 {
+		drained := make(chan struct{})
+		state.drained = drained
+		state.mu.Unlock()
+		<-drained
+		state.mu.Lock()
+	}
+	err := state.impl.close()
+	if err == nil {
+		state.impl = nil
+	}
+	state.closing = false
+	return err
+}
 
-	if rc := C.liberasurecode_decode(
-		backend.libecDesc, cFrags, C.int(len(frags)),
-		C.uint64_t(len(frags[0])), C.int(1),
-		&data, &dataLength); rc != 0 {
-		return nil, fmt.Errorf("decode() returned %v", errToName(-rc))
+// Encode splits data into K+M fragments. Zero-length data is allowed; it
+// is encoded like a single zero byte, but with an original size of zero
+// recorded in each header.
+func (backend *Backend) Encode(data []byte) ([][]byte, error) {
+	impl, release := backend.acquire()
+	defer release()
+	return impl.encode(data)
+}
+
+func (backend *Backend) Decode(frags [][]byte) ([]byte, error) {
+	return backend.decode(frags, true)
+}
+
+func (backend *Backend) decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("decoding requires at least one fragment: %w", ErrInsufficientFragments)
 	}
-	defer C.liberasurecode_decode_cleanup(backend.libecDesc, data)
-	runtime.KeepAlive(frags) // prevent frags from being GC-ed during decode
-	return C.GoBytes(unsafe.Pointer(data), C.int(dataLength)), nil
+	impl, release := backend.acquire()
+	defer release()
+	return impl.decode(frags, forceMetadataChecks)
 }
 
 func (backend *Backend) Reconstruct(frags [][]byte, fragIndex int) ([]byte, error) {
 	if len(frags) == 0 {
-		return nil, errors.New("reconstruction requires at least one fragment")
+		return nil, fmt.Errorf("reconstruction requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	impl, release := backend.acquire()
+	defer release()
+	return impl.reconstruct(frags, fragIndex)
+}
+
+// ReconstructMany rebuilds the fragments at each of indexes from frags,
+// which must include at least K other fragments. Rather than repeating
+// the work of Reconstruct for each index, the pure-Go backends recover the
+// data once and compute each fragment from it; others decode frags and
+// re-encode the result.
+func (backend *Backend) ReconstructMany(frags [][]byte, indexes []int) (map[int][]byte, error) {
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("reconstruction requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	n := backend.K + backend.M
+	for _, idx := range indexes {
+		if idx < 0 || idx >= n {
+			return nil, fmt.Errorf("index %d out of range for %d fragments: %w", idx, n, ErrInvalidParams)
+		}
+	}
+	switch len(indexes) {
+	case 0:
+		return map[int][]byte{}, nil
+	case 1:
+		frag, err := backend.Reconstruct(frags, indexes[0])
+		if err != nil {
+			return nil, err
+		}
+		return map[int][]byte{indexes[0]: frag}, nil
+	}
+
+	impl, release := backend.acquire()
+	defer release()
+	if many, ok := impl.(interface {
+		reconstructMany(ctx context.Context, frags [][]byte, indexes []int) (map[int][]byte, error)
+	}); ok {
+		return many.reconstructMany(context.Background(), frags, indexes)
+	}
+	data, err := impl.decode(frags, false)
+	if err != nil {
+		return nil, err
 	}
-	fragLength := len(frags[0])
-	data := make([]byte, fragLength)
-	pData := (*C.char)(unsafe.Pointer(&data[0]))
+	encoded, err := impl.encode(data)
+	if err != nil {
+		return nil, err
+	}
+	rebuilt := make(map[int][]byte, len(indexes))
+	for _, idx := range indexes {
+		rebuilt[idx] = encoded[idx]
+	}
+	return rebuilt, nil
+}
+
+// AlignedDataSize returns the number of bytes dataLen will be padded to
+// before being split across the K data fragments.
+func (backend *Backend) AlignedDataSize(dataLen int) (int, error) {
+	impl, release := backend.acquire()
+	defer release()
+	return impl.alignedDataSize(dataLen)
+}
+
+// MinimumEncodeSize returns the smallest buffer that can be encoded without
+// padding; any smaller input is padded up to this size.
+func (backend *Backend) MinimumEncodeSize() (int, error) {
+	impl, release := backend.acquire()
+	defer release()
+	return impl.minimumEncodeSize()
+}
+
+// FragmentSize returns the size of each fragment's payload (including any
+// backend-specific metadata, but not the fragment header) when encoding
+// dataLen bytes. Each fragment returned by Encode is FragmentHeaderSize
+// bytes longer than this.
+func (backend *Backend) FragmentSize(dataLen int) (int, error) {
+	impl, release := backend.acquire()
+	defer release()
+	return impl.fragmentSize(dataLen)
+}
 
-	cFrags := C.makeStrArray(C.int(len(frags)))
-	defer C.freeStrArray(cFrags)
-	for index, frag := range frags {
-		C.setStrArrayItem(cFrags, C.int(index), (*C.uchar)(&frag[0]))
+// FragmentsNeeded plans a read for recovery. Given the fragment indexes the
+// caller wants to rebuild and those known to be unavailable, it returns the
+// indexes of a minimal set of fragments to fetch. The result may be passed
+// (with the fragments' data) to Reconstruct to rebuild each wanted index, or
+// to Decode when want is empty and exclude just lists missing fragments.
+//
+// With the Reed-Solomon backends any K fragments will do, so more than M
+// unavailable is rejected outright. Other codes are less regular:
+// flat_xor_hd guarantees recovery from only HD-1 losses, though some larger
+// patterns can be recovered too, so which can is left to the backend
+// (liberasurecode's fragments_needed). Either way, a pattern that can't be
+// recovered from gives an error wrapping ErrInsufficientFragments.
+func (backend *Backend) FragmentsNeeded(want []int, exclude []int) ([]int, error) {
+	n := backend.K + backend.M
+	missing := make(map[int]bool, len(want)+len(exclude))
+	check := func(indexes []int, what string) error {
+		for _, idx := range indexes {
+			if idx < 0 || idx >= n {
+				return fmt.Errorf("%s index %d out of range for %d fragments: %w",
+					what, idx, n, ErrInvalidParams)
+			}
+			missing[idx] = true
+		}
+		return nil
+	}
+	if err := check(want, "wanted"); err != nil {
+		return nil, err
+	}
+	if err := check(exclude, "excluded"); err != nil {
+		return nil, err
+	}
+	if id, _ := nameToID(backend.Name); anyKBackends[id] && len(missing) > backend.M {
+		return nil, fmt.Errorf("%d fragments unavailable, but only %d parity fragments: %w",
+			len(missing), backend.M, ErrInsufficientFragments)
 	}
 
-	if rc := C.liberasurecode_reconstruct_fragment(
-		backend.libecDesc, cFrags, C.int(len(frags)),
-		C.uint64_t(len(frags[0])), C.int(fragIndex), pData); rc != 0 {
-		return nil, fmt.Errorf("reconstruct_fragment() returned %v", errToName(-rc))
+	impl, release := backend.acquire()
+	defer release()
+	needed, err := impl.fragmentsNeeded(want, exclude)
+	if errors.Is(err, ErrBackendFailure) {
+		// fragments_needed fails with EPERM when it finds no way to
+		// recover.
+		return nil, fmt.Errorf("no way to recover with %d fragments unavailable (%v): %w",
+			len(missing), err, ErrInsufficientFragments)
 	}
-	runtime.KeepAlive(frags) // prevent frags from being GC-ed during reconstruct
-	return data, nil
+	return needed, err
+}
+
+// anyKBackends lists the backends whose codes can recover from any M
+// losses: any K fragments will do.
+var anyKBackends = map[BackendID]bool{
+	backendJerasureRSVand:       true,
+	backendJerasureRSCauchy:     true,
+	backendIsaLRSVand:           true,
+	backendLiberasurecodeRSVand: true,
+	backendIsaLRSCauchy:         true,
 }
 
 func (backend *Backend) IsInvalidFragment(frag []byte) bool {
-	pData := (*C.char)(unsafe.Pointer(&frag[0]))
-	return 1 == C.is_invalid_fragment(backend.libecDesc, pData)
+	impl, release := backend.acquire()
+	defer release()
+	return impl.isInvalidFragment(frag)
+}
+
+// VerifyFragment checks that frag is a fragment this backend could have
+// produced, and that its payload matches the checksum stored in its header.
+// Unlike IsInvalidFragment, it reports why the fragment was rejected: the
+// error wraps ErrBadChecksum for payload corruption and ErrBadHeader for
+// anything else.
+func (backend *Backend) VerifyFragment(frag []byte) error {
+	if err := VerifyFragmentChecksum(frag); err != nil {
+		return err
+	}
+	if backend.IsInvalidFragment(frag) {
+		return fmt.Errorf("fragment not valid for %v backend: %w", backend.Name, ErrBadHeader)
+	}
+	return nil
 }
 
 type FragmentInfo struct {
@@ -210,27 +624,101 @@ type FragmentInfo struct {
 	Size                int
 	BackendMetadataSize int
 	OrigDataSize        uint64
-	BackendID           C.ec_backend_id_t
+	BackendID           BackendID
 	BackendName         string
 	BackendVersion      Version
 	ErasureCodeVersion  Version
 	IsValid             bool
 	MetadataChecksum    uint32
+	// LegacyMetadataChecksum is set if MetadataChecksum was computed with
+	// liberasurecode's legacy CRC routine (see
+	// LIBERASURECODE_WRITE_LEGACY_CRC) rather than the standard CRC32.
+	LegacyMetadataChecksum bool
+	ChecksumType           ChecksumType
+	Checksum               [maxChecksumLen]uint32
+	ChecksumMismatch       bool
 }
 
+// GetFragmentInfo reads the header at the start of frag. If frag is too
+// short to hold a header, the zero FragmentInfo (with IsValid unset) is
+// returned; use ParseFragmentInfo to get an error instead.
 func GetFragmentInfo(frag []byte) FragmentInfo {
-	header := *(*C.struct_fragment_header_s)(unsafe.Pointer(&frag[0]))
-	backendID := C.getBackendID(&header)
+	if len(frag) < FragmentHeaderSize {
+		return FragmentInfo{}
+	}
+	info := makeFragmentInfo(parseHeader(frag))
+	info.IsValid = isValidHeader(frag)
+	info.LegacyMetadataChecksum = hasLegacyMetadataChecksum(frag)
+	return info
+}
+
+// ParseFragmentInfo reads the header at the start of frag, returning an
+// error wrapping ErrBadHeader if frag is too short or the header fails its
+// metadata checksum. In the latter case, the info is returned as well.
+func ParseFragmentInfo(frag []byte) (FragmentInfo, error) {
+	if len(frag) < FragmentHeaderSize {
+		return FragmentInfo{}, fmt.Errorf("fragment too short for header (%d bytes): %w", len(frag), ErrBadHeader)
+	}
+	info := GetFragmentInfo(frag)
+	if !info.IsValid {
+		return info, fmt.Errorf("metadata checksum failed: %w", ErrBadHeader)
+	}
+	return info, nil
+}
+
+func makeFragmentInfo(h fragmentHeader) FragmentInfo {
 	return FragmentInfo{
-		Index:               int(header.meta.idx),
-		Size:                int(header.meta.size),
-		BackendMetadataSize: int(header.meta.frag_backend_metadata_size),
-		OrigDataSize:        uint64(C.getOrigDataSize(&header)),
-		BackendID:           backendID,
-		BackendName:         idToName(backendID),
-		BackendVersion:      makeVersion(C.getBackendVersion(&header)),
-		ErasureCodeVersion:  makeVersion(C.getECVersion(&header)),
-		IsValid:             C.is_invalid_fragment_header((*C.fragment_header_t)(&header)) == 0,
-		MetadataChecksum:    uint32(C.getMetadataChksum(&header)),
+		Index:               int(h.index),
+		Size:                int(h.size),
+		BackendMetadataSize: int(h.backendMetadataSize),
+		OrigDataSize:        h.origDataSize,
+		BackendID:           BackendID(h.backendID),
+		BackendName:         idToName(BackendID(h.backendID)),
+		BackendVersion:      makeVersion(h.backendVersion),
+		ErasureCodeVersion:  makeVersion(h.libecVersion),
+		MetadataChecksum:    h.metadataChecksum,
+		ChecksumType:        ChecksumType(h.checksumType),
+		Checksum:            h.checksum,
+		ChecksumMismatch:    h.checksumMismatch != 0,
+	}
+}
+
+// VerifyFragmentChecksum recomputes the payload checksum of frag and compares
+// it against the one stored in the fragment header. Fragments written without
+// a payload checksum pass trivially. CRC32 checksums may have been written
+// with either the current or the legacy CRC routine; both are accepted.
+func VerifyFragmentChecksum(frag []byte) error {
+	if len(frag) < FragmentHeaderSize {
+		return fmt.Errorf("fragment too short for header (%d bytes): %w", len(frag), ErrBadHeader)
+	}
+	info := GetFragmentInfo(frag)
+	if !info.IsValid {
+		return fmt.Errorf("metadata checksum failed: %w", ErrBadHeader)
+	}
+	return verifyPayload(info, frag)
+}
+
+// verifyPayload checks frag's payload against the checksum in info, without
+// regard to whether the header itself is intact.
+func verifyPayload(info FragmentInfo, frag []byte) error {
+	payload := frag[FragmentHeaderSize:]
+	if len(payload) < info.Size {
+		return fmt.Errorf("fragment truncated; expected %d payload bytes, got %d: %w",
+			info.Size, len(payload), ErrBadHeader)
+	}
+	payload = payload[:info.Size]
+	switch info.ChecksumType {
+	case 0, ChecksumNone:
+		return nil
+	case ChecksumCRC32:
+		if crc32.ChecksumIEEE(payload) != info.Checksum[0] &&
+			legacyCRC32(payload) != info.Checksum[0] {
+			return fmt.Errorf("payload CRC32 mismatch for fragment %d: %w", info.Index, ErrBadChecksum)
+		}
+		return nil
+	case ChecksumMD5:
+		return fmt.Errorf("verifying %v checksums: %w", info.ChecksumType, ErrMethodNotImplemented)
+	default:
+		return fmt.Errorf("unknown checksum type %d: %w", uint8(info.ChecksumType), ErrBadHeader)
 	}
 }
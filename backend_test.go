@@ -2,10 +2,12 @@ package erasurecode
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"math/rand"
 	"os"
+	"reflect"
 	"sync"
 	"testing"
 )
@@ -60,6 +62,13 @@ var testPatterns = [][]byte{
 	bytes.Repeat([]byte{0x55}, 1024),
 }
 
+// knownButUnavailable reports whether name is a backend we know of but
+// can't use in this build.
+func knownButUnavailable(name string) bool {
+	_, err := nameToID(name)
+	return err == nil && !BackendIsAvailable(name)
+}
+
 func shuf(src [][]byte) [][]byte {
 	dest := make([][]byte, len(src))
 	perm := rand.Perm(len(src))
@@ -94,8 +103,8 @@ func TestInitBackend(t *testing.T) {
 				t.Errorf("%q", err)
 				continue
 			}
-			if backend.libecDesc <= 0 {
-				t.Errorf("Expected backend descriptor > 0, got %d", backend.libecDesc)
+			if backend.current() == nil {
+				t.Errorf("Expected backend %v to be initialized", params)
 			}
 
 			if err = backend.Close(); err != nil {
@@ -114,19 +123,22 @@ func TestInitBackendFailure(t *testing.T) {
 		want   string
 	}{
 		{Params{Name: "liberasurecode_rs_vand", K: -1, M: 1},
-			"instance_create() returned EINVALIDPARAMS"},
+			"invalid K for liberasurecode_rs_vand backend: K=-1, must be at least 1"},
 		{Params{Name: "liberasurecode_rs_vand", K: 10, M: -1},
-			"instance_create() returned EINVALIDPARAMS"},
+			"invalid M for liberasurecode_rs_vand backend: M=-1, must not be negative"},
 		{Params{Name: "non-existent-backend", K: 10, M: 4},
 			"unsupported backend \"non-existent-backend\""},
 		{Params{Name: "", K: 10, M: 4},
 			"unsupported backend \"\""},
 		{Params{Name: "liberasurecode_rs_vand", K: 20, M: 20},
-			"instance_create() returned EINVALIDPARAMS"},
+			"invalid M for liberasurecode_rs_vand backend: K+M=40, must be at most 32"},
 		{Params{Name: "flat_xor_hd", K: 4, M: 4, HD: 3},
 			"instance_create() returned EBACKENDINITERR"},
 	}
 	for _, args := range cases {
+		if knownButUnavailable(args.params.Name) {
+			continue
+		}
 		backend, err := InitBackend(args.params)
 		if err == nil {
 			t.Errorf("Expected error when calling InitBackend(%v)",
@@ -138,14 +150,98 @@ func TestInitBackendFailure(t *testing.T) {
 			t.Errorf("InitBackend(%v) produced error %q, want %q",
 				args.params, err, args.want)
 		}
-		if backend.libecDesc != 0 {
-			t.Errorf("InitBackend(%v) produced backend with descriptor %v, want 0",
-				args.params, backend.libecDesc)
+		if backend.current() != nil {
+			t.Errorf("InitBackend(%v) produced initialized backend %v",
+				args.params, backend.current())
 			_ = backend.Close()
 		}
 	}
 }
 
+func TestInitBackendErrorTypes(t *testing.T) {
+	cases := []struct {
+		params Params
+		want   error
+		errno  int
+	}{
+		{Params{Name: "liberasurecode_rs_vand", K: -1, M: 1},
+			ErrInvalidParams, 0},
+		{Params{Name: "non-existent-backend", K: 10, M: 4},
+			ErrBackendNotSupported, 0},
+		{Params{Name: "flat_xor_hd", K: 4, M: 4, HD: 3},
+			ErrBackendInitError, 202},
+	}
+	for _, args := range cases {
+		if knownButUnavailable(args.params.Name) {
+			continue
+		}
+		_, err := InitBackend(args.params)
+		if !errors.Is(err, args.want) {
+			t.Errorf("InitBackend(%v) produced error %v, want %v",
+				args.params, err, args.want)
+			continue
+		}
+		var ecErr *Error
+		if args.errno == 0 {
+			if errors.As(err, &ecErr) {
+				t.Errorf("InitBackend(%v) unexpectedly produced *Error %#v",
+					args.params, ecErr)
+			}
+			continue
+		}
+		if !errors.As(err, &ecErr) {
+			t.Errorf("InitBackend(%v) produced %T, want *Error", args.params, err)
+			continue
+		}
+		if ecErr.Op != "instance_create" || ecErr.Errno != args.errno ||
+			ecErr.Backend != args.params.Name || ecErr.Params != args.params {
+			t.Errorf("InitBackend(%v) produced unexpected error details %#v",
+				args.params, ecErr)
+		}
+	}
+}
+
+func TestDecodeInsufficientFragments(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend, err := InitBackend(params)
+	if err != nil {
+		t.Fatalf("Error creating backend %v: %q", params, err)
+	}
+	defer backend.Close()
+	frags, err := backend.Encode(bytes.Repeat([]byte("X"), 1000))
+	if err != nil {
+		t.Fatalf("Error encoding: %q", err)
+	}
+	if _, err := backend.Decode(frags[:params.K-1]); !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+	if _, err := backend.Decode([][]byte{}); !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+}
+
+func TestErrToName(t *testing.T) {
+	for errno, name := range map[int]string{
+		1:   "EPERM",
+		12:  "ENOMEM",
+		22:  "EINVAL",
+		200: "EBACKENDNOTSUPP",
+		201: "EECMETHODNOTIMPL",
+		202: "EBACKENDINITERR",
+		203: "EBACKENDINUSE",
+		204: "EBACKENDNOTAVAIL",
+		205: "EBADCHKSUM",
+		206: "EINVALIDPARAMS",
+		207: "EBADHEADER",
+		208: "EINSUFFFRAGS",
+		999: "<unknown error code 999>",
+	} {
+		if got := errnoName(errno); got != name {
+			t.Errorf("Expected errno %d to be %s, got %s", errno, name, got)
+		}
+	}
+}
+
 func TestEncodeDecode(t *testing.T) {
 	for _, group := range validParamGroups {
 		t.Run(group.name, func(t *testing.T) {
@@ -294,6 +390,212 @@ func TestReconstruct(t *testing.T) {
 	}
 }
 
+func TestFragmentsNeeded(t *testing.T) {
+	for _, group := range validParamGroups {
+		t.Run(group.name, func(t *testing.T) {
+			var ranOne = false
+			for _, params := range group.params {
+				if !BackendIsAvailable(params.Name) {
+					continue
+				}
+				ranOne = true
+				backend, err := InitBackend(params)
+				if err != nil {
+					t.Errorf("Error creating backend %v: %q", params, err)
+					continue
+				}
+				pattern := testPatterns[7]
+				frags, err := backend.Encode(pattern)
+				if err != nil {
+					t.Fatalf("Error encoding %v: %q", params, err)
+				}
+
+				perm := rand.Perm(params.K + params.M)
+				want, exclude := perm[:1], perm[1:params.M]
+				needed, err := backend.FragmentsNeeded(want, exclude)
+				if err != nil {
+					t.Errorf("%v: FragmentsNeeded(%v, %v) failed: %v", params, want, exclude, err)
+				} else {
+					var available [][]byte
+					for _, idx := range needed {
+						for _, missing := range perm[:params.M] {
+							if idx == missing {
+								t.Errorf("%v: FragmentsNeeded(%v, %v) asked for unavailable index %v", params, want, exclude, idx)
+							}
+						}
+						available = append(available, frags[idx])
+					}
+					if len(needed) != params.K {
+						t.Errorf("%v: Expected %v fragments needed, got %v", params, params.K, needed)
+					}
+					data, err := backend.Reconstruct(available, want[0])
+					if err != nil {
+						t.Errorf("%v: Error reconstructing from %v: %v", params, needed, err)
+					} else if !bytes.Equal(data, frags[want[0]]) {
+						t.Errorf("%v: Reconstructing from %v produced the wrong fragment", params, needed)
+					}
+				}
+
+				needed, err = backend.FragmentsNeeded(nil, perm[:params.M])
+				if err != nil {
+					t.Errorf("%v: FragmentsNeeded(nil, %v) failed: %v", params, perm[:params.M], err)
+				} else {
+					var available [][]byte
+					for _, idx := range needed {
+						available = append(available, frags[idx])
+					}
+					data, err := backend.Decode(available)
+					if err != nil {
+						t.Errorf("%v: Error decoding from %v: %v", params, needed, err)
+					} else if !bytes.Equal(data, pattern) {
+						t.Errorf("%v: Decoding from %v produced the wrong data", params, needed)
+					}
+				}
+
+				if _, err := backend.FragmentsNeeded(nil, perm[:params.M+1]); !errors.Is(err, ErrInsufficientFragments) {
+					t.Errorf("%v: Expected ErrInsufficientFragments with too many exclusions, got %v", params, err)
+				}
+				if _, err := backend.FragmentsNeeded([]int{params.K + params.M}, nil); !errors.Is(err, ErrInvalidParams) {
+					t.Errorf("%v: Expected ErrInvalidParams with out-of-range index, got %v", params, err)
+				}
+				if _, err := backend.FragmentsNeeded(nil, []int{-1}); !errors.Is(err, ErrInvalidParams) {
+					t.Errorf("%v: Expected ErrInvalidParams with negative index, got %v", params, err)
+				}
+
+				if err = backend.Close(); err != nil {
+					t.Errorf("Error closing backend %v: %q", backend, err)
+				}
+			}
+			if !ranOne {
+				t.Skip()
+			}
+		})
+	}
+}
+
+// plannerEngine answers fragmentsNeeded with plan; nothing else may be
+// called.
+type plannerEngine struct {
+	engine
+	plan func(want, exclude []int) ([]int, error)
+}
+
+func (e plannerEngine) fragmentsNeeded(want, exclude []int) ([]int, error) {
+	return e.plan(want, exclude)
+}
+
+func TestFragmentsNeededFlatXor(t *testing.T) {
+	params := Params{Name: "flat_xor_hd", K: 3, M: 3, HD: 3}
+	// Only HD-1 losses are sure to be recoverable; the backend decides
+	// about the rest.
+	calls := 0
+	backend := newBackend(params, plannerEngine{plan: func(want, exclude []int) ([]int, error) {
+		calls++
+		if len(want)+len(exclude) > 3 {
+			return nil, newError("fragments_needed", params, -errnoEPERM)
+		}
+		return []int{0, 4, 5}, nil
+	}})
+	if needed, err := backend.FragmentsNeeded(nil, []int{1, 2, 3}); err != nil || !reflect.DeepEqual(needed, []int{0, 4, 5}) {
+		t.Errorf("Expected [0 4 5] from the backend, got %v (%v)", needed, err)
+	}
+	if _, err := backend.FragmentsNeeded([]int{0}, []int{1, 2, 3}); !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the backend to be asked both times, was asked %d", calls)
+	}
+
+	if !BackendIsAvailable(params.Name) {
+		t.Skipf("%v not available", params.Name)
+	}
+	backend, err := InitBackend(params)
+	if err != nil {
+		t.Fatalf("Error creating backend %v: %v", params, err)
+	}
+	defer backend.Close()
+	frags, err := backend.Encode(testPatterns[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := params.K + params.M
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			needed, err := backend.FragmentsNeeded(nil, []int{a, b})
+			if err != nil {
+				t.Errorf("FragmentsNeeded(nil, [%d %d]) failed: %v", a, b, err)
+				continue
+			}
+			var available [][]byte
+			for _, idx := range needed {
+				available = append(available, frags[idx])
+			}
+			if data, err := backend.Decode(available); err != nil || !bytes.Equal(data, testPatterns[7]) {
+				t.Errorf("Error decoding from %v: %v", needed, err)
+			}
+		}
+	}
+	if _, err := backend.FragmentsNeeded(nil, []int{0, 1, 2, 3}); !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments with M+1 losses, got %v", err)
+	}
+}
+
+func TestSizes(t *testing.T) {
+	for _, group := range validParamGroups {
+		t.Run(group.name, func(t *testing.T) {
+			var ranOne = false
+			for _, params := range group.params {
+				if !BackendIsAvailable(params.Name) {
+					continue
+				}
+				ranOne = true
+				backend, err := InitBackend(params)
+				if err != nil {
+					t.Errorf("Error creating backend %v: %q", params, err)
+					continue
+				}
+				minSize, err := backend.MinimumEncodeSize()
+				if err != nil {
+					t.Errorf("%v: MinimumEncodeSize failed: %v", params, err)
+				} else if aligned, err := backend.AlignedDataSize(1); err != nil || aligned != minSize {
+					t.Errorf("%v: Expected minimum encode size %v to match aligned size of 1 byte, got %v (%v)", params, minSize, aligned, err)
+				}
+				for _, size := range []int{1, 2, 999, 1000, 1 << 16, 1<<20 + 1} {
+					aligned, err := backend.AlignedDataSize(size)
+					if err != nil {
+						t.Errorf("%v: AlignedDataSize(%v) failed: %v", params, size, err)
+						continue
+					}
+					if aligned < size || aligned%params.K != 0 {
+						t.Errorf("%v: AlignedDataSize(%v) returned %v", params, size, aligned)
+					}
+					fragSize, err := backend.FragmentSize(size)
+					if err != nil {
+						t.Errorf("%v: FragmentSize(%v) failed: %v", params, size, err)
+						continue
+					}
+					frags, err := backend.Encode(make([]byte, size))
+					if err != nil {
+						t.Errorf("%v: Error encoding %v bytes: %v", params, size, err)
+						continue
+					}
+					for index, frag := range frags {
+						if len(frag) != FragmentHeaderSize+fragSize {
+							t.Errorf("%v: Expected frag %v to be %v bytes for %v-byte input, got %v", params, index, FragmentHeaderSize+fragSize, size, len(frag))
+						}
+					}
+				}
+				if err = backend.Close(); err != nil {
+					t.Errorf("Error closing backend %v: %q", backend, err)
+				}
+			}
+			if !ranOne {
+				t.Skip()
+			}
+		})
+	}
+}
+
 func TestIsInvalidFragment(t *testing.T) {
 	for _, group := range validParamGroups {
 		t.Run(group.name, func(t *testing.T) {
@@ -422,12 +724,66 @@ func TestIsInvalidFragment(t *testing.T) {
 	}
 }
 
-func TestBackendIsAvailable(t *testing.T) {
-	requiredBackends := []string{
-		"null",
-		"flat_xor_hd",
-		"liberasurecode_rs_vand",
+func TestVerifyFragment(t *testing.T) {
+	for _, ct := range []ChecksumType{0, ChecksumNone, ChecksumCRC32} {
+		t.Run(ct.String(), func(t *testing.T) {
+			params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2, ChecksumType: ct}
+			backend, err := InitBackend(params)
+			if err != nil {
+				t.Fatalf("Error creating backend %v: %q", params, err)
+			}
+			defer backend.Close()
+			expectedType := ct
+			if expectedType == 0 {
+				expectedType = ChecksumCRC32
+			}
+			for patternIndex, pattern := range testPatterns {
+				frags, err := backend.Encode(pattern)
+				if err != nil {
+					t.Fatalf("Error encoding %v: %q", params, err)
+				}
+				for index, frag := range frags {
+					info := GetFragmentInfo(frag)
+					if info.ChecksumType != expectedType {
+						t.Errorf("Expected frag %v to have checksum type %v; got %v", index, expectedType, info.ChecksumType)
+					}
+					if expectedType == ChecksumCRC32 {
+						if want := crc32.ChecksumIEEE(frag[80:]); info.Checksum[0] != want {
+							t.Errorf("Expected frag %v to have payload CRC %x; got %x", index, want, info.Checksum[0])
+						}
+					}
+					if err := backend.VerifyFragment(frag); err != nil {
+						t.Errorf("frag %v unexpectedly failed verification for pattern %d: %v", index, patternIndex, err)
+					}
+
+					if err := VerifyFragmentChecksum(frag[:len(frag)-1]); !errors.Is(err, ErrBadHeader) {
+						t.Errorf("Expected ErrBadHeader for truncated frag %v, got %v", index, err)
+					}
+					if err := VerifyFragmentChecksum(frag[:40]); !errors.Is(err, ErrBadHeader) {
+						t.Errorf("Expected ErrBadHeader for frag %v with truncated header, got %v", index, err)
+					}
+
+					corruptedByte := 80 + rand.Intn(len(frag)-80)
+					frag[corruptedByte] ^= 0xff
+					err := VerifyFragmentChecksum(frag)
+					if expectedType == ChecksumCRC32 {
+						if !errors.Is(err, ErrBadChecksum) {
+							t.Errorf("Expected ErrBadChecksum after inverting byte %d of frag %v, got %v", corruptedByte, index, err)
+						}
+						if err := backend.VerifyFragment(frag); !errors.Is(err, ErrBadChecksum) {
+							t.Errorf("Expected ErrBadChecksum from backend after inverting byte %d of frag %v, got %v", corruptedByte, index, err)
+						}
+					} else if err != nil {
+						t.Errorf("Expected no checksum error without checksums, got %v", err)
+					}
+					frag[corruptedByte] ^= 0xff
+				}
+			}
+		})
 	}
+}
+
+func TestBackendIsAvailable(t *testing.T) {
 	optionalBackends := []string{
 		"isa_l_rs_vand",
 		"isa_l_rs_cauchy",
@@ -629,3 +985,389 @@ func TestLegacyCRC(t *testing.T) {
 		})
 	}
 }
+
+func TestParamsValidate(t *testing.T) {
+	for _, group := range validParamGroups {
+		for _, params := range group.params {
+			if err := params.Validate(); err != nil {
+				t.Errorf("Expected %v to be valid, got %v", params, err)
+			}
+		}
+	}
+	for _, params := range []Params{
+		{Name: "null", K: 4, M: 0},
+		{Name: "jerasure_rs_vand", K: 10, M: 4, W: 8},
+		{Name: "flat_xor_hd", K: 3, M: 3, HD: 3},
+		{Name: "isa_l_rs_vand", K: 10, M: 4, W: 7}, // W is ignored
+	} {
+		if err := params.Validate(); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", params, err)
+		}
+	}
+
+	cases := []struct {
+		params Params
+		field  string
+	}{
+		{Params{Name: "isa_l_rs_vand", K: 0, M: 4}, "K"},
+		{Params{Name: "isa_l_rs_vand", K: 33, M: 4}, "K"},
+		{Params{Name: "isa_l_rs_vand", K: 10, M: 0}, "M"},
+		{Params{Name: "isa_l_rs_vand", K: 30, M: 4}, "M"},
+		{Params{Name: "null", K: 4, M: -1}, "M"},
+		{Params{Name: "jerasure_rs_vand", K: 10, M: 4, W: 12}, "W"},
+		{Params{Name: "jerasure_rs_cauchy", K: 15, M: 4}, "W"},
+		{Params{Name: "jerasure_rs_cauchy", K: 10, M: 4, W: 3}, "W"},
+		{Params{Name: "flat_xor_hd", K: 3, M: 3}, "HD"},
+		{Params{Name: "flat_xor_hd", K: 3, M: 3, HD: 5}, "HD"},
+		{Params{Name: "flat_xor_hd", K: 3, M: 2, HD: 3}, "M"},
+		{Params{Name: "isa_l_rs_vand", K: 10, M: 4, ChecksumType: 9}, "ChecksumType"},
+	}
+	for _, args := range cases {
+		err := args.params.Validate()
+		var paramsErr *ParamsError
+		if !errors.As(err, &paramsErr) || !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("Validate(%v) produced %v, want *ParamsError", args.params, err)
+			continue
+		}
+		if paramsErr.Field != args.field || paramsErr.Params != args.params {
+			t.Errorf("Validate(%v) produced unexpected error details %#v", args.params, paramsErr)
+		}
+		if _, err := InitBackend(args.params); !errors.As(err, &paramsErr) {
+			t.Errorf("InitBackend(%v) produced %v, want *ParamsError", args.params, err)
+		}
+	}
+	if err := (Params{Name: "unknown", K: 1, M: 1}).Validate(); !errors.Is(err, ErrBackendNotSupported) {
+		t.Errorf("Expected ErrBackendNotSupported, got %v", err)
+	}
+}
+
+func TestBackendCapabilities(t *testing.T) {
+	for _, name := range KnownBackends {
+		caps, err := BackendCapabilities(name)
+		if err != nil {
+			t.Errorf("%v: Error getting capabilities: %v", name, err)
+			continue
+		}
+		if caps.Name != name {
+			t.Errorf("%v: Expected name %q, got %q", name, name, caps.Name)
+		}
+		if caps.Available != BackendIsAvailable(name) {
+			t.Errorf("%v: Expected Available=%v, got %v (%v)", name, BackendIsAvailable(name), caps.Available, caps.Unavailable)
+		}
+		if caps.Available != (caps.Unavailable == nil) {
+			t.Errorf("%v: Available=%v inconsistent with Unavailable=%v", name, caps.Available, caps.Unavailable)
+		}
+		if !caps.Available && !errors.Is(caps.Unavailable, ErrBackendNotAvailable) {
+			t.Errorf("%v: Expected ErrBackendNotAvailable, got %v", name, caps.Unavailable)
+		}
+		if caps.K.Min < 1 || caps.K.Max > caps.MaxFragments || caps.MaxFragments == 0 {
+			t.Errorf("%v: Unexpected K range %v, max fragments %v", name, caps.K, caps.MaxFragments)
+		}
+	}
+	for _, name := range []string{"jerasure_rs_vand", "jerasure_rs_cauchy"} {
+		if caps, _ := BackendCapabilities(name); caps.NeedsHD || len(caps.W) == 0 || caps.DefaultW == 0 {
+			t.Errorf("%v: Expected to take W with a default, got %+v", name, caps)
+		}
+	}
+	if caps, _ := BackendCapabilities("flat_xor_hd"); !caps.NeedsHD || !caps.HD.Contains(3) {
+		t.Errorf("flat_xor_hd: Expected to need HD, got %+v", caps)
+	}
+	caps, _ := BackendCapabilities("jerasure_rs_vand")
+	want := append([]int(nil), caps.W...)
+	caps.W[0] = -1
+	if caps, _ := BackendCapabilities("jerasure_rs_vand"); !reflect.DeepEqual(caps.W, want) {
+		t.Errorf("Expected W %v despite changes to an earlier result, got %v", want, caps.W)
+	}
+	if caps, _ := BackendCapabilities("shss"); caps.Systematic {
+		t.Errorf("shss: Expected not to be systematic")
+	}
+	if _, err := BackendCapabilities("unknown"); !errors.Is(err, ErrBackendNotSupported) {
+		t.Errorf("Expected ErrBackendNotSupported, got %v", err)
+	}
+}
+
+func TestShortAndEmptyInputs(t *testing.T) {
+	if info := GetFragmentInfo(nil); info.IsValid || info != (FragmentInfo{}) {
+		t.Errorf("Expected zero info for nil fragment, got %+v", info)
+	}
+	if info := GetFragmentInfo(make([]byte, FragmentHeaderSize-1)); info.IsValid {
+		t.Errorf("Expected short fragment to be invalid")
+	}
+	if _, err := ParseFragmentInfo(make([]byte, 10)); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("Expected ErrBadHeader for short fragment, got %v", err)
+	}
+
+	for _, group := range validParamGroups {
+		for _, params := range group.params {
+			if !BackendIsAvailable(params.Name) {
+				continue
+			}
+			backend, err := InitBackend(params)
+			if err != nil {
+				t.Fatalf("Error creating backend %v: %q", params, err)
+			}
+			n := params.K + params.M
+
+			frags, err := backend.Encode(nil)
+			if err != nil || len(frags) != n {
+				t.Fatalf("%v: Expected %d fragments for empty object, got %d (%v)", params, n, len(frags), err)
+			}
+			info, err := ParseFragmentInfo(frags[0])
+			if err != nil || info.OrigDataSize != 0 {
+				t.Errorf("%v: Expected valid header with zero size, got %+v (%v)", params, info, err)
+			}
+			for _, subset := range [][][]byte{frags[:params.K], frags[params.M:]} {
+				data, err := backend.Decode(shuf(subset))
+				if err != nil || len(data) != 0 {
+					t.Errorf("%v: Expected empty object, got %q (%v)", params, data, err)
+				}
+			}
+			if frag, err := backend.Reconstruct(frags[1:], 0); err != nil || !bytes.Equal(frag, frags[0]) {
+				t.Errorf("%v: Error reconstructing empty object's fragment: %v", params, err)
+			}
+
+			frags, err = backend.Encode(testPatterns[3])
+			if err != nil {
+				t.Fatalf("%v: Error encoding: %v", params, err)
+			}
+			if _, err := backend.Decode([][]byte{nil, {}, frags[0][:10]}); err == nil {
+				t.Errorf("%v: Expected error decoding short fragments", params)
+			}
+			truncated := append([][]byte{frags[0][:len(frags[0])-1]}, frags[1:params.K]...)
+			if _, err := backend.Decode(truncated); err == nil {
+				t.Errorf("%v: Expected error decoding truncated fragment", params)
+			}
+			if _, err := backend.Reconstruct(truncated, n-1); err == nil {
+				t.Errorf("%v: Expected error reconstructing from truncated fragment", params)
+			}
+			if !backend.IsInvalidFragment(nil) || !backend.IsInvalidFragment(truncated[0]) {
+				t.Errorf("%v: Expected short fragments to be invalid", params)
+			}
+
+			// Trailing bytes are ignored, even on just one fragment
+			padded := make([][]byte, len(frags)-1)
+			copy(padded, frags[1:])
+			padded[0] = append(append([]byte{}, padded[0]...), "junk"...)
+			if frag, err := backend.Reconstruct(padded, 0); err != nil || !bytes.Equal(frag, frags[0]) {
+				t.Errorf("%v: Error reconstructing with padded fragment: %v", params, err)
+			}
+
+			// But fragments of different sizes can't be combined
+			others, err := backend.Encode(testPatterns[0])
+			if err != nil {
+				t.Fatalf("%v: Error encoding: %v", params, err)
+			}
+			if len(others[0]) != len(frags[0]) {
+				mixed := append([][]byte{others[0]}, frags[1:]...)
+				if _, err := backend.Reconstruct(mixed, n-1); !errors.Is(err, ErrBadHeader) {
+					t.Errorf("%v: Expected ErrBadHeader for mixed fragment sizes, got %v", params, err)
+				}
+			}
+
+			if err := backend.Close(); err != nil {
+				t.Errorf("Error closing backend %v: %q", params, err)
+			}
+		}
+	}
+}
+
+func TestReconstructMany(t *testing.T) {
+	for _, group := range validParamGroups {
+		for _, params := range group.params {
+			if !BackendIsAvailable(params.Name) {
+				continue
+			}
+			backend, err := InitBackend(params)
+			if err != nil {
+				t.Fatalf("Error creating backend %v: %q", params, err)
+			}
+			n := params.K + params.M
+			for patternIndex, pattern := range testPatterns[:4] {
+				frags, err := backend.Encode(pattern)
+				if err != nil {
+					t.Fatalf("%v: Error encoding pattern %d: %v", params, patternIndex, err)
+				}
+				// Lose M fragments: the first M, the last M, and a random M
+				order := rand.Perm(n)
+				for _, lost := range [][]int{
+					seq(0, params.M),
+					seq(params.K, n),
+					order[:params.M],
+				} {
+					isLost := make(map[int]bool)
+					for _, idx := range lost {
+						isLost[idx] = true
+					}
+					var remaining [][]byte
+					for idx, frag := range frags {
+						if !isLost[idx] {
+							remaining = append(remaining, frag)
+						}
+					}
+					rebuilt, err := backend.ReconstructMany(shuf(remaining), lost)
+					if err != nil {
+						t.Errorf("%v: Error reconstructing %v for pattern %d: %v", params, lost, patternIndex, err)
+						continue
+					}
+					if len(rebuilt) != len(lost) {
+						t.Errorf("%v: Expected %d fragments, got %d", params, len(lost), len(rebuilt))
+					}
+					for _, idx := range lost {
+						if !bytes.Equal(rebuilt[idx], frags[idx]) {
+							t.Errorf("%v: Reconstructed frag %d differs for pattern %d", params, idx, patternIndex)
+						}
+					}
+					if _, err := backend.ReconstructMany(remaining[1:], lost); err == nil {
+						t.Errorf("%v: Expected error reconstructing %v from too few fragments", params, lost)
+					}
+				}
+			}
+			if _, err := backend.ReconstructMany([][]byte{{}}, []int{n}); !errors.Is(err, ErrInvalidParams) {
+				t.Errorf("%v: Expected ErrInvalidParams for out-of-range index, got %v", params, err)
+			}
+			if rebuilt, err := backend.ReconstructMany([][]byte{{}}, nil); err != nil || len(rebuilt) != 0 {
+				t.Errorf("%v: Expected nothing to do, got %v (%v)", params, rebuilt, err)
+			}
+			if err := backend.Close(); err != nil {
+				t.Errorf("Error closing backend %v: %q", params, err)
+			}
+		}
+	}
+}
+
+func TestReconstructManyByReencoding(t *testing.T) {
+	// Hide rsEngine's reconstructMany to exercise the generic path.
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 3}
+	goBackend := initGoBackend(t, params)
+	backend := newBackend(params, struct{ engine }{goBackend.current()})
+	for _, pattern := range [][]byte{nil, testPatterns[7]} {
+		frags, err := backend.Encode(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rebuilt, err := backend.ReconstructMany(frags[3:], []int{0, 2, 1})
+		if err != nil {
+			t.Fatalf("Error reconstructing: %v", err)
+		}
+		for idx := 0; idx < 3; idx++ {
+			if !bytes.Equal(rebuilt[idx], frags[idx]) {
+				t.Errorf("Reconstructed frag %d differs", idx)
+			}
+		}
+	}
+}
+
+// seq returns the integers in [start, end).
+func seq(start, end int) []int {
+	s := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+// blockingEngine holds up encode calls until unblock is closed, signalling
+// on started as each one begins.
+type blockingEngine struct {
+	engine
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (e blockingEngine) encode(data []byte) ([][]byte, error) {
+	e.started <- struct{}{}
+	<-e.unblock
+	return e.engine.encode(data)
+}
+
+func TestCloseWaitsForCalls(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	goBackend := initGoBackend(t, params)
+	impl := blockingEngine{
+		goBackend.current(),
+		make(chan struct{}),
+		make(chan struct{}),
+	}
+	backend := newBackend(params, impl)
+	other := backend // copies share the instance
+
+	encoded := make(chan error)
+	go func() {
+		_, err := backend.Encode(testPatterns[2])
+		encoded <- err
+	}()
+	<-impl.started
+
+	closed := make(chan error)
+	go func() {
+		closed <- other.Close()
+	}()
+	// Once Close has started, new calls on any copy fail.
+	for {
+		_, err := backend.AlignedDataSize(1000)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrBackendClosed) || !errors.Is(err, ErrBackendNotAvailable) {
+			t.Fatalf("Expected ErrBackendClosed, got %v", err)
+		}
+		break
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned (%v) with a call in progress", err)
+	default:
+	}
+
+	close(impl.unblock)
+	if err := <-encoded; err != nil {
+		t.Errorf("Error encoding during Close: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("Error closing backend: %v", err)
+	}
+	if _, err := other.Encode(testPatterns[2]); !errors.Is(err, ErrBackendClosed) {
+		t.Errorf("Expected ErrBackendClosed after Close, got %v", err)
+	}
+	if err := backend.Close(); !errors.Is(err, ErrBackendClosed) {
+		t.Errorf("Expected ErrBackendClosed closing twice, got %v", err)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend := initGoBackend(t, params)
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(b Backend) {
+			defer wg.Done()
+			for {
+				frags, err := b.Encode(testPatterns[3])
+				if err == nil {
+					_, err = b.Decode(frags[params.M:])
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(backend)
+	}
+	if err := backend.Close(); err != nil {
+		t.Errorf("Error closing backend: %v", err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrBackendClosed) {
+			t.Errorf("Expected ErrBackendClosed, got %v", err)
+		}
+	}
+
+	var zero Backend
+	if _, err := zero.Encode(testPatterns[3]); !errors.Is(err, ErrBackendNotAvailable) || errors.Is(err, ErrBackendClosed) {
+		t.Errorf("Expected ErrBackendNotAvailable from an uninitialized backend, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package erasurecode
+
+import (
+	"fmt"
+	"sync"
+)
+
+// bufferEngine is implemented by engines that can write their output into
+// buffers supplied by the caller, rather than allocating their own.
+type bufferEngine interface {
+	encodeTo(data []byte, dst [][]byte) error
+	decodeTo(frags [][]byte, forceMetadataChecks bool, dst []byte) ([]byte, error)
+	reconstructTo(frags [][]byte, fragIndex int, dst []byte) ([]byte, error)
+}
+
+// bufferEncoder is implemented by Coders, such as Backend, that can encode
+// into buffers supplied by the caller.
+type bufferEncoder interface {
+	EncodeTo(data []byte, dst [][]byte) error
+}
+
+// bufferDecoder is implemented by Coders, such as Backend, that can decode
+// into a buffer supplied by the caller.
+type bufferDecoder interface {
+	DecodeTo(frags [][]byte, dst []byte) ([]byte, error)
+}
+
+// EncodeTo is Encode, but writes the fragments into dst, which must hold
+// K+M slices. Each dst[i] is replaced by fragment i, reusing its storage if
+// its capacity allows. Buffers from a BufferPool may be passed in and put
+// back once the fragments have been written out, so encoding a stream of
+// segments needn't allocate for each.
+func (backend *Backend) EncodeTo(data []byte, dst [][]byte) error {
+	if n := backend.K + backend.M; len(dst) != n {
+		return fmt.Errorf("%d buffers given for %d fragments: %w", len(dst), n, ErrInvalidParams)
+	}
+	impl, release := backend.acquire()
+	defer release()
+	if b, ok := impl.(bufferEngine); ok {
+		return b.encodeTo(data, dst)
+	}
+	frags, err := impl.encode(data)
+	if err != nil {
+		return err
+	}
+	copyFragments(dst, frags)
+	return nil
+}
+
+// DecodeTo is Decode, but writes the data into dst's storage if its
+// capacity allows, returning the slice of it holding the data.
+func (backend *Backend) DecodeTo(frags [][]byte, dst []byte) ([]byte, error) {
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("decoding requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	impl, release := backend.acquire()
+	defer release()
+	if b, ok := impl.(bufferEngine); ok {
+		return b.decodeTo(frags, true, dst)
+	}
+	data, err := impl.decode(frags, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(resize(dst, 0), data...), nil
+}
+
+// ReconstructTo is Reconstruct, but writes the fragment into dst's storage
+// if its capacity allows, returning the slice of it holding the fragment.
+func (backend *Backend) ReconstructTo(frags [][]byte, fragIndex int, dst []byte) ([]byte, error) {
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("reconstruction requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	impl, release := backend.acquire()
+	defer release()
+	if b, ok := impl.(bufferEngine); ok {
+		return b.reconstructTo(frags, fragIndex, dst)
+	}
+	frag, err := impl.reconstruct(frags, fragIndex)
+	if err != nil {
+		return nil, err
+	}
+	return append(resize(dst, 0), frag...), nil
+}
+
+// resize returns buf with length n, reusing its storage if it is large
+// enough. The contents are not cleared.
+func resize(buf []byte, n int) []byte {
+	if buf != nil && cap(buf) >= n {
+		return buf[:n]
+	}
+	return make([]byte, n)
+}
+
+func zero(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// copyFragments copies each of frags into the corresponding buffer in dst,
+// for engines that can't write into dst directly.
+func copyFragments(dst, frags [][]byte) {
+	for i, frag := range frags {
+		dst[i] = append(resize(dst[i], 0), frag...)
+	}
+}
+
+// A BufferPool recycles byte slices, such as the fragment buffers passed to
+// EncodeTo. It is a thin wrapper around sync.Pool, so buffers that aren't
+// reused are eventually freed. The zero BufferPool is ready to use.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// Get returns a buffer of length size, reusing one put back earlier if it
+// is large enough. Get(0) returns any buffer available, for use with
+// EncodeTo and the like, which grow the buffers they are given as needed.
+func (p *BufferPool) Get(size int) []byte {
+	if v := p.pool.Get(); v != nil {
+		if buf := *v.(*[]byte); cap(buf) >= size {
+			return buf[:size]
+		}
+	}
+	return make([]byte, size)
+}
+
+// Put returns buf to the pool. It must not be used afterwards.
+func (p *BufferPool) Put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	p.pool.Put(&buf)
+}
+
+// fragmentBuffers is the BufferPool an ECWriter uses if it isn't given one.
+var fragmentBuffers BufferPool
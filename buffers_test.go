@@ -0,0 +1,210 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// dirtyBuffers returns n buffers of the given capacity, full of junk.
+func dirtyBuffers(n, capacity int) [][]byte {
+	bufs := make([][]byte, n)
+	for i := range bufs {
+		bufs[i] = bytes.Repeat([]byte{0xa5}, capacity)[:1]
+	}
+	return bufs
+}
+
+func TestEncodeTo(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	goBackend := initGoBackend(t, params)
+	isal := initGoBackend(t, Params{Name: "isa_l_rs_vand", K: 4, M: 2})
+	for _, tc := range []struct {
+		name    string
+		backend Backend
+	}{
+		{"liberasurecode_rs_vand", goBackend},
+		{"isa_l_rs_vand", isal},
+		// Hide the engine's bufferEngine methods to exercise copying.
+		{"fallback", newBackend(params, struct{ engine }{goBackend.current()})},
+	} {
+		backend := tc.backend
+		n := params.K + params.M
+		for patternIndex, pattern := range append([][]byte{nil}, testPatterns...) {
+			want, err := backend.Encode(pattern)
+			if err != nil {
+				t.Fatalf("%v: Error encoding pattern %d: %v", tc.name, patternIndex, err)
+			}
+			for _, capacity := range []int{1, 2 << 20} {
+				dst := dirtyBuffers(n, capacity)
+				storage := make([][]byte, n)
+				copy(storage, dst)
+				if err := backend.EncodeTo(pattern, dst); err != nil {
+					t.Fatalf("%v: Error encoding pattern %d into buffers: %v", tc.name, patternIndex, err)
+				}
+				for i := range dst {
+					if !bytes.Equal(dst[i], want[i]) {
+						t.Errorf("%v: frag %d differs for pattern %d", tc.name, i, patternIndex)
+					}
+					if reused := &dst[i][0] == &storage[i][0]; reused != (capacity >= len(want[i])) {
+						t.Errorf("%v: frag %d: storage reused %v with capacity %d", tc.name, i, reused, capacity)
+					}
+				}
+
+				data, err := backend.DecodeTo(dst[params.M:], bytes.Repeat([]byte{0xa5}, capacity)[:0])
+				if err != nil {
+					t.Errorf("%v: Error decoding pattern %d into a buffer: %v", tc.name, patternIndex, err)
+				} else if !bytes.Equal(data, pattern) || data == nil {
+					t.Errorf("%v: pattern %d did not round-trip", tc.name, patternIndex)
+				}
+
+				for idx, frags := range map[int][][]byte{0: dst[1 : params.K+1], params.K: dst[:params.K]} {
+					frag, err := backend.ReconstructTo(frags, idx, dirtyBuffers(1, capacity)[0])
+					if err != nil {
+						t.Errorf("%v: Error reconstructing frag %d: %v", tc.name, idx, err)
+					} else if !bytes.Equal(frag, want[idx]) {
+						t.Errorf("%v: reconstructed frag %d differs for pattern %d", tc.name, idx, patternIndex)
+					}
+				}
+			}
+		}
+		if err := backend.EncodeTo(testPatterns[2], make([][]byte, n-1)); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%v: Expected ErrInvalidParams for too few buffers, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	var pool BufferPool
+	for _, size := range []int{0, 1, 1 << 16, 10} {
+		buf := pool.Get(size)
+		if len(buf) != size {
+			t.Errorf("Get(%d) returned %d bytes", size, len(buf))
+		}
+		pool.Put(buf)
+	}
+}
+
+func benchmarkBackend(b *testing.B) Backend {
+	params := Params{Name: "liberasurecode_rs_vand", K: 10, M: 4}
+	backend, err := InitBackend(params)
+	if err != nil {
+		b.Skipf("Error creating backend %v: %v", params, err)
+	}
+	return backend
+}
+
+func benchmarkSegment() []byte {
+	data := make([]byte, DefaultSegmentSize)
+	rand.Read(data)
+	return data
+}
+
+func BenchmarkEncode(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+	data := benchmarkSegment()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.Encode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeTo(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+	data := benchmarkSegment()
+	var pool BufferPool
+	frags := make([][]byte, backend.K+backend.M)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range frags {
+			frags[j] = pool.Get(0)
+		}
+		if err := backend.EncodeTo(data, frags); err != nil {
+			b.Fatal(err)
+		}
+		for _, frag := range frags {
+			pool.Put(frag)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+	data := benchmarkSegment()
+	frags, err := backend.Encode(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.Decode(frags[backend.M:]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTo(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+	data := benchmarkSegment()
+	frags, err := backend.Encode(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = backend.DecodeTo(frags[backend.M:], buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReconstruct(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+	frags, err := backend.Encode(benchmarkSegment())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(frags[0])))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.Reconstruct(frags[1:], 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReconstructTo(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+	frags, err := backend.Encode(benchmarkSegment())
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, len(frags[0]))
+	b.SetBytes(int64(len(frags[0])))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = backend.ReconstructTo(frags[1:], 0, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package erasurecode
+
+import "fmt"
+
+// Range is an inclusive range of parameter values.
+type Range struct {
+	Min int
+	Max int
+}
+
+// Contains reports whether v lies within r.
+func (r Range) Contains(v int) bool {
+	return r.Min <= v && v <= r.Max
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// Capabilities describes what a backend supports and whether it can be used.
+type Capabilities struct {
+	Name string
+	// Systematic backends store the original data unchanged in the K
+	// data fragments.
+	Systematic bool
+	K          Range
+	M          Range
+	// MaxFragments limits K+M.
+	MaxFragments int
+	// W lists the valid word sizes for backends that take one; others
+	// ignore W. Zero selects DefaultW.
+	W        []int
+	DefaultW int
+	// HD is the valid range of Hamming distances for backends that take
+	// one.
+	HD      Range
+	NeedsHD bool
+	// Library is the shared library liberasurecode loads for the backend.
+	Library string
+	// Available is true if InitBackend can create the backend.
+	Available bool
+	// Unavailable explains why the backend cannot be used; it's nil when
+	// Available is true. It wraps ErrBackendNotAvailable, or the error
+	// returned when creating a test instance failed.
+	Unavailable error
+	// sample is a minimal set of parameters used to check that a
+	// built-in backend initializes.
+	sample Params
+}
+
+var builtinCapabilities = map[BackendID]Capabilities{
+	backendNull: {
+		Systematic: true, K: Range{1, 32}, M: Range{0, 32}, MaxFragments: maxFragments,
+		Library: "libnullcode.so.1", sample: Params{K: 2, M: 1},
+	},
+	backendJerasureRSVand: {
+		Systematic: true, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		W: []int{8, 16, 32}, DefaultW: 16,
+		Library: "libJerasure.so.2", sample: Params{K: 2, M: 1},
+	},
+	backendJerasureRSCauchy: {
+		Systematic: true, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		W: []int{4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
+			21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}, DefaultW: 4,
+		Library: "libJerasure.so.2", sample: Params{K: 2, M: 1},
+	},
+	backendFlatXorHD: {
+		Systematic: true, K: Range{1, 20}, M: Range{3, 6}, MaxFragments: 26,
+		HD: Range{3, 4}, NeedsHD: true,
+		Library: "libXorcode.so.1", sample: Params{K: 3, M: 3, HD: 3},
+	},
+	backendIsaLRSVand: {
+		Systematic: true, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		Library: "libisal.so.2", sample: Params{K: 2, M: 1},
+	},
+	backendSHSS: {
+		Systematic: false, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		Library: "libshss.so.1", sample: Params{K: 2, M: 1},
+	},
+	backendLiberasurecodeRSVand: {
+		Systematic: true, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		Library: "liberasurecode_rs_vand.so.1", sample: Params{K: 2, M: 1},
+	},
+	backendIsaLRSCauchy: {
+		Systematic: true, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		Library: "libisal.so.2", sample: Params{K: 2, M: 1},
+	},
+	backendLibphazr: {
+		Systematic: false, K: Range{1, 32}, M: Range{1, 32}, MaxFragments: maxFragments,
+		Library: "libphazr.so.1", sample: Params{K: 2, M: 1},
+	},
+}
+
+// BackendCapabilities describes the named backend, including (if it can't
+// be used) why not. Availability is checked by creating a small instance
+// of the backend, so this is more thorough -- and more expensive -- than
+// BackendIsAvailable.
+//
+// Backends added with RegisterBackend report whatever capabilities were
+// given in their BackendFactory.
+func BackendCapabilities(name string) (Capabilities, error) {
+	id, err := nameToID(name)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps := builtinCapabilities[id]
+	factory, registered := registeredBackend(name)
+	if registered {
+		caps = factory.Capabilities
+		caps.sample = Params{}
+	}
+	caps.Name = name
+	// Callers get their own copy, so can't alter what later calls return.
+	caps.W = append([]int(nil), caps.W...)
+
+	_, hasGoEngine := goEngines[id]
+	switch {
+	case registered && factory.New != nil, hasGoEngine, libecBackendAvailable(id):
+	case !libecLinked:
+		caps.Unavailable = fmt.Errorf("%v requires liberasurecode, but cgo is disabled: %w",
+			name, ErrBackendNotAvailable)
+		return caps, nil
+	case caps.Library != "":
+		caps.Unavailable = fmt.Errorf("liberasurecode could not load %v for %v: %w",
+			caps.Library, name, ErrBackendNotAvailable)
+		return caps, nil
+	default:
+		caps.Unavailable = fmt.Errorf("liberasurecode does not provide %v: %w",
+			name, ErrBackendNotAvailable)
+		return caps, nil
+	}
+
+	if caps.sample.K != 0 {
+		params := caps.sample
+		params.Name = name
+		backend, err := InitBackend(params)
+		if err != nil {
+			caps.Unavailable = fmt.Errorf("%v failed to initialize: %w", name, err)
+			return caps, nil
+		}
+		backend.Close()
+	}
+	caps.Available = true
+	return caps, nil
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"os"
 
 	"github.com/tipabu/erasurecode"
@@ -14,20 +13,43 @@ var numData = flag.Int("k", 0, "number of data fragments")
 var numParity = flag.Int("m", 0, "number of parity fragments")
 var wordSize = flag.Int("w", 0, "word size, in bits")
 var hammingDistance = flag.Int("d", 0, "Hamming distance, for flat_xor_hd")
-var bufferSize = flag.Int("s", 1<<20, "chunk size, in bytes")
+var bufferSize = flag.Int("s", 1<<20, "segment size, in bytes")
+var policyName = flag.String("p", "", "a policy such as isa_l_rs_vand:k=10,m=4, or with -c, a policy name or index")
+var swiftConf = flag.String("c", "", "a swift.conf to read storage policies from")
 
 func init() {
 	flag.Usage = func() {
-		fmt.Printf("usage: %s -b backend -k K -m M [-w W] [-d HD] [-s size] file\n\n", os.Args[0])
+		fmt.Printf("usage: %s -b backend -k K -m M [-w W] [-d HD] [-s size] file\n", os.Args[0])
+		fmt.Printf("       %s -p policy [-c swift.conf] [-s size] file\n\n", os.Args[0])
 		fmt.Println("Split a file into K + M fragment archives.")
 		flag.PrintDefaults()
-		fmt.Println("\nAvailable backends:")
-		for _, name := range erasurecode.AvailableBackends() {
-			fmt.Println("    " + name)
+		fmt.Println("\nBackends:")
+		for _, name := range erasurecode.KnownBackends {
+			if caps, err := erasurecode.BackendCapabilities(name); err == nil {
+				fmt.Println("    " + describe(caps))
+			}
 		}
 	}
 }
 
+// describe summarizes caps in a line of usage output.
+func describe(caps erasurecode.Capabilities) string {
+	if !caps.Available {
+		return fmt.Sprintf("%s: unavailable (%v)", caps.Name, caps.Unavailable)
+	}
+	desc := fmt.Sprintf("%s: -k %v, -m %v, K+M <= %d", caps.Name, caps.K, caps.M, caps.MaxFragments)
+	if len(caps.W) > 0 {
+		desc += fmt.Sprintf(", -w one of %v (default %d)", caps.W, caps.DefaultW)
+	}
+	if caps.NeedsHD {
+		desc += fmt.Sprintf(", -d %v", caps.HD)
+	}
+	if !caps.Systematic {
+		desc += " (not systematic)"
+	}
+	return desc
+}
+
 func checkErr(err error) {
 	if err != nil {
 		flag.Usage()
@@ -37,45 +59,65 @@ func checkErr(err error) {
 	}
 }
 
-func copyNAtATime(output io.Writer, input io.Reader, n int) (written uint64, err error) {
-	buf := make([]byte, n)
-	for {
-		nr, er := io.ReadFull(input, buf)
-		if nr > 0 {
-			nw, ew := output.Write(buf[:n])
-			written += uint64(nw)
-			if ew != nil {
-				err = ew
-				return
-			}
-			if nw != nr {
-				err = io.ErrShortWrite
-				return
-			}
+// getParams builds Params from -p (and -c), or else from -b, -k, -m, -w
+// and -d. A policy from swift.conf also sets the chunk size, unless -s was
+// given.
+func getParams() (erasurecode.Params, error) {
+	if *policyName == "" {
+		if *swiftConf != "" {
+			return erasurecode.Params{}, fmt.Errorf("-c requires -p")
 		}
-		if er != nil {
-			if er != io.EOF && er != io.ErrUnexpectedEOF {
-				err = er
-			}
-			return
+		if *backendName == "" {
+			return erasurecode.Params{}, fmt.Errorf("missing required flag -b")
+		}
+		if *numData == 0 {
+			return erasurecode.Params{}, fmt.Errorf("missing required flag -k")
 		}
+		if *numParity == 0 && *backendName != "null" {
+			return erasurecode.Params{}, fmt.Errorf("missing required flag -m")
+		}
+		return erasurecode.Params{
+			Name: *backendName,
+			K:    *numData,
+			M:    *numParity,
+			W:    *wordSize,
+			HD:   *hammingDistance,
+		}, nil
+	}
+	if *swiftConf == "" {
+		return erasurecode.ParsePolicy(*policyName)
 	}
+
+	policies, err := erasurecode.LoadSwiftPolicies(*swiftConf)
+	if err != nil {
+		return erasurecode.Params{}, err
+	}
+	policy, ok := erasurecode.FindPolicy(policies, *policyName)
+	if !ok {
+		return erasurecode.Params{}, fmt.Errorf("no erasure-coded policy %q in %s", *policyName, *swiftConf)
+	}
+	sizeSet := false
+	flag.Visit(func(f *flag.Flag) {
+		sizeSet = sizeSet || f.Name == "s"
+	})
+	if !sizeSet {
+		*bufferSize = int(policy.SegmentSize)
+	}
+	return policy.Params, nil
 }
 
 func main() {
 	flag.Parse()
 
-	if *backendName == "" {
-		checkErr(fmt.Errorf("missing required flag -b"))
-	}
-	if !erasurecode.BackendIsAvailable(*backendName) {
+	params, err := getParams()
+	checkErr(err)
+	caps, err := erasurecode.BackendCapabilities(params.Name)
+	if err != nil {
 		checkErr(fmt.Errorf("backend must be one of %v", erasurecode.AvailableBackends()))
 	}
-	if *numData == 0 {
-		checkErr(fmt.Errorf("missing required flag -k"))
-	}
-	if *numParity == 0 && *backendName != "null" {
-		checkErr(fmt.Errorf("missing required flag -m"))
+	checkErr(caps.Unavailable)
+	if caps.NeedsHD && params.HD == 0 {
+		checkErr(fmt.Errorf("%v requires -d, in the range %v", params.Name, caps.HD))
 	}
 	if len(flag.Args()) != 1 {
 		checkErr(fmt.Errorf("expected exactly one file to split"))
@@ -83,16 +125,9 @@ func main() {
 	input := flag.Args()[0]
 	prefix := &input
 
-	backend, err := erasurecode.InitBackend(erasurecode.Params{
-		Name: *backendName,
-		K:    *numData,
-		M:    *numParity,
-		W:    *wordSize,
-		HD:   *hammingDistance,
-	})
+	backend, err := erasurecode.InitBackend(params)
 	checkErr(err)
 	defer backend.Close()
-
 	fd, err := os.Open(input)
 	checkErr(err)
 	defer fd.Close()
@@ -100,11 +135,20 @@ func main() {
 	info, err := fd.Stat()
 	checkErr(err)
 
-	output, err := backend.GetFileWriter(*prefix, info.Mode())
+	output, err := erasurecode.NewFileWriter(&backend, *prefix, info.Mode())
 	checkErr(err)
-	defer output.Close()
+	output.SegmentSize = *bufferSize
 
-	n, err := copyNAtATime(output, fd, *bufferSize)
+	_, err = output.ReadFrom(fd)
+	if err == nil {
+		// the final segment is only written out on Close
+		err = output.Close()
+	} else {
+		output.Close()
+	}
 	checkErr(err)
-	fmt.Printf("%v bytes copied\n", n)
+	for _, failure := range output.Failures() {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", failure)
+	}
+	fmt.Printf("%v bytes copied\n", output.Length())
 }
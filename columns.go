@@ -0,0 +1,266 @@
+package erasurecode
+
+import (
+	"context"
+	"hash/crc32"
+)
+
+// columnarBackends lists the backends whose codes work column by column:
+// byte (or word) j of each parity fragment depends only on byte j of each
+// data fragment. An operation on large fragments can then be split into
+// several on narrower slices of them, each a call of its own, with the
+// same result -- which is how the context variants make liberasurecode
+// calls interruptible.
+var columnarBackends = map[BackendID]bool{
+	backendJerasureRSVand:       true,
+	backendFlatXorHD:            true,
+	backendIsaLRSVand:           true,
+	backendLiberasurecodeRSVand: true,
+	backendIsaLRSCauchy:         true,
+}
+
+// columnar reports whether operations for params may be split into column
+// ranges. Only checksums that can be recomputed over the whole payload
+// afterwards are supported.
+func columnar(params Params) bool {
+	id, err := nameToID(params.Name)
+	if err != nil || !columnarBackends[id] {
+		return false
+	}
+	switch params.ChecksumType {
+	case 0, ChecksumNone, ChecksumCRC32:
+		return true
+	}
+	return false
+}
+
+// forColumnRanges calls fn for each range of at most columnChunk bytes of
+// [0, size), in turn, checking ctx before each.
+func forColumnRanges(ctx context.Context, size int, fn func(lo, hi int) error) error {
+	for lo := 0; lo < size; lo += columnChunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hi := lo + columnChunk
+		if hi > size {
+			hi = size
+		}
+		if err := fn(lo, hi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeColumns encodes data with impl, a column at a time if its
+// fragments would be large, so ctx is checked between calls.
+func encodeColumns(ctx context.Context, impl engine, params Params, data []byte) ([][]byte, error) {
+	k := params.K
+	aligned, err := impl.alignedDataSize(len(data))
+	if err != nil {
+		return nil, err
+	}
+	blockSize := aligned / k
+	if !columnar(params) || blockSize <= columnChunk {
+		return impl.encode(data)
+	}
+
+	frags := make([][]byte, k+params.M)
+	legacy := make([]bool, len(frags))
+	var sub []byte
+	err = forColumnRanges(ctx, blockSize, func(lo, hi int) error {
+		// Lay the columns out as the data of a narrower encode, whose
+		// blocks may be padded out to stride.
+		subAligned, err := impl.alignedDataSize(k * (hi - lo))
+		if err != nil {
+			return err
+		}
+		stride := subAligned / k
+		sub = resize(sub, subAligned)
+		zero(sub)
+		for i := 0; i < k; i++ {
+			start, end := i*blockSize+lo, i*blockSize+hi
+			if end > len(data) {
+				end = len(data)
+			}
+			if start < end {
+				copy(sub[i*stride:], data[start:end])
+			}
+		}
+		chunk, err := impl.encode(sub)
+		if err != nil {
+			return err
+		}
+		if len(chunk) != len(frags) {
+			return newError("encode", params, -errnoEBADHEADER)
+		}
+		for j, c := range chunk {
+			if len(c) < FragmentHeaderSize+stride {
+				return newError("encode", params, -errnoEBADHEADER)
+			}
+			if frags[j] == nil {
+				frags[j] = make([]byte, FragmentHeaderSize+blockSize)
+				copy(frags[j], c[:FragmentHeaderSize])
+				legacy[j] = hasLegacyPayloadChecksum(c[:FragmentHeaderSize+stride])
+			}
+			copy(frags[j][FragmentHeaderSize+lo:FragmentHeaderSize+hi], c[FragmentHeaderSize:])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for j, frag := range frags {
+		setColumnsHeader(frag, uint64(len(data)), legacy[j])
+	}
+	return frags, nil
+}
+
+// decodeColumns decodes frags with impl, a column at a time if they are
+// large, so ctx is checked between calls.
+func decodeColumns(ctx context.Context, impl engine, params Params, frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	blockSize, origDataSize, ok := columnsLayout(params, frags)
+	if !ok {
+		return impl.decode(frags, forceMetadataChecks)
+	}
+	k := params.K
+	data := make([]byte, k*blockSize)
+	subs := make([][]byte, len(frags))
+	err := forColumnRanges(ctx, blockSize, func(lo, hi int) error {
+		stride, err := sliceColumns(impl, params, frags, subs, lo, hi)
+		if err != nil {
+			return err
+		}
+		chunk, err := impl.decode(subs, forceMetadataChecks)
+		if err != nil {
+			return err
+		}
+		if len(chunk) != k*stride {
+			return newError("decode", params, -errnoEBADHEADER)
+		}
+		for i := 0; i < k; i++ {
+			copy(data[i*blockSize+lo:i*blockSize+hi], chunk[i*stride:])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data[:origDataSize], nil
+}
+
+// reconstructColumns rebuilds fragment fragIndex with impl, a column at a
+// time if the fragments are large, so ctx is checked between calls.
+func reconstructColumns(ctx context.Context, impl engine, params Params, frags [][]byte, fragIndex int) ([]byte, error) {
+	blockSize, origDataSize, ok := columnsLayout(params, frags)
+	if !ok {
+		return impl.reconstruct(frags, fragIndex)
+	}
+	frag := make([]byte, FragmentHeaderSize+blockSize)
+	legacy := false
+	subs := make([][]byte, len(frags))
+	err := forColumnRanges(ctx, blockSize, func(lo, hi int) error {
+		stride, err := sliceColumns(impl, params, frags, subs, lo, hi)
+		if err != nil {
+			return err
+		}
+		chunk, err := impl.reconstruct(subs, fragIndex)
+		if err != nil {
+			return err
+		}
+		if len(chunk) < FragmentHeaderSize+stride {
+			return newError("reconstruct_fragment", params, -errnoEBADHEADER)
+		}
+		if lo == 0 {
+			copy(frag, chunk[:FragmentHeaderSize])
+			legacy = hasLegacyPayloadChecksum(chunk[:FragmentHeaderSize+stride])
+		}
+		copy(frag[FragmentHeaderSize+lo:FragmentHeaderSize+hi], chunk[FragmentHeaderSize:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	setColumnsHeader(frag, origDataSize, legacy)
+	return frag, nil
+}
+
+// columnsLayout checks that frags can be split into column ranges: the
+// backend is columnar, the fragments are large, and their headers are
+// valid and agree. Anything else is left to a single call, to be handled
+// (or rejected) as usual.
+func columnsLayout(params Params, frags [][]byte) (blockSize int, origDataSize uint64, ok bool) {
+	if !columnar(params) || len(frags) == 0 {
+		return 0, 0, false
+	}
+	for i, frag := range frags {
+		if len(frag) < FragmentHeaderSize || !isValidHeader(frag) {
+			return 0, 0, false
+		}
+		h := parseHeader(frag)
+		if h.backendMetadataSize != 0 || len(frag) < FragmentHeaderSize+int(h.size) {
+			return 0, 0, false
+		}
+		if i == 0 {
+			blockSize, origDataSize = int(h.size), h.origDataSize
+		} else if int(h.size) != blockSize || h.origDataSize != origDataSize {
+			return 0, 0, false
+		}
+	}
+	if blockSize <= columnChunk || origDataSize > uint64(params.K*blockSize) {
+		return 0, 0, false
+	}
+	return blockSize, origDataSize, true
+}
+
+// sliceColumns fills subs with the fragments of a narrower encode holding
+// columns [lo, hi) of frags, returning their (possibly padded) payload
+// size.
+func sliceColumns(impl engine, params Params, frags, subs [][]byte, lo, hi int) (int, error) {
+	subAligned, err := impl.alignedDataSize(params.K * (hi - lo))
+	if err != nil {
+		return 0, err
+	}
+	stride := subAligned / params.K
+	for i, frag := range frags {
+		sub := resize(subs[i], FragmentHeaderSize+stride)
+		copy(sub, frag[:FragmentHeaderSize])
+		n := copy(sub[FragmentHeaderSize:], frag[FragmentHeaderSize+lo:FragmentHeaderSize+hi])
+		zero(sub[FragmentHeaderSize+n:])
+		setColumnsHeader(sub, uint64(subAligned), false)
+		subs[i] = sub
+	}
+	return stride, nil
+}
+
+// setColumnsHeader fixes up the header of frag, assembled from column
+// ranges, for its full payload and origDataSize, recomputing checksums with
+// the routines used before.
+func setColumnsHeader(frag []byte, origDataSize uint64, legacyPayload bool) {
+	legacyMetadata := hasLegacyMetadataChecksum(frag)
+	h := parseHeader(frag)
+	h.size = uint32(len(frag) - FragmentHeaderSize)
+	h.origDataSize = origDataSize
+	if ChecksumType(h.checksumType) == ChecksumCRC32 {
+		if legacyPayload {
+			h.checksum[0] = legacyCRC32(frag[FragmentHeaderSize:])
+		} else {
+			h.checksum[0] = crc32.ChecksumIEEE(frag[FragmentHeaderSize:])
+		}
+	}
+	h.put(frag)
+	if h.libecVersion >= metadataChecksumVersion {
+		setMetadataChecksum(frag, legacyMetadata)
+	}
+}
+
+// hasLegacyPayloadChecksum reports whether frag's payload checksum was
+// computed with liberasurecode's legacy CRC routine.
+func hasLegacyPayloadChecksum(frag []byte) bool {
+	h := parseHeader(frag)
+	if ChecksumType(h.checksumType) != ChecksumCRC32 {
+		return false
+	}
+	payload := frag[FragmentHeaderSize:]
+	return h.checksum[0] != crc32.ChecksumIEEE(payload) && h.checksum[0] == legacyCRC32(payload)
+}
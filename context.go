@@ -0,0 +1,143 @@
+package erasurecode
+
+import (
+	"context"
+	"fmt"
+)
+
+// contextEngine is implemented by engines that can be interrupted part way
+// through an operation. liberasurecode calls can't be, so libecEngine
+// doesn't; the context variants split large operations into several calls
+// instead, where the backend allows.
+type contextEngine interface {
+	encodeContext(ctx context.Context, data []byte) ([][]byte, error)
+	decodeContext(ctx context.Context, frags [][]byte, forceMetadataChecks bool) ([]byte, error)
+	reconstructContext(ctx context.Context, frags [][]byte, fragIndex int) ([]byte, error)
+}
+
+// EncodeContext is Encode, but returns ctx.Err() if ctx is done, checking
+// as it goes so large inputs can be abandoned part way through. The
+// pure-Go backends check ctx within their own loops. A liberasurecode call
+// can't be interrupted once started, so for large inputs the work is split
+// into calls on narrower slices of the fragments, checking ctx between
+// them; the fragments are the same as Encode's. That's only possible for
+// the Reed-Solomon and flat_xor_hd backends, with CRC32 or no checksums;
+// others make a single call. EncodeSegmentsContext splits any input, but
+// into segments with fragments of their own.
+func (backend *Backend) EncodeContext(ctx context.Context, data []byte) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	impl, release := backend.acquire()
+	defer release()
+	if c, ok := impl.(contextEngine); ok {
+		return c.encodeContext(ctx, data)
+	}
+	return encodeColumns(ctx, impl, backend.Params, data)
+}
+
+// DecodeContext is Decode, but gives up if ctx is done; see EncodeContext.
+func (backend *Backend) DecodeContext(ctx context.Context, frags [][]byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("decoding requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	impl, release := backend.acquire()
+	defer release()
+	if c, ok := impl.(contextEngine); ok {
+		return c.decodeContext(ctx, frags, true)
+	}
+	return decodeColumns(ctx, impl, backend.Params, frags, true)
+}
+
+// ReconstructContext is Reconstruct, but gives up if ctx is done; see
+// EncodeContext.
+func (backend *Backend) ReconstructContext(ctx context.Context, frags [][]byte, fragIndex int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(frags) == 0 {
+		return nil, fmt.Errorf("reconstruction requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	impl, release := backend.acquire()
+	defer release()
+	if c, ok := impl.(contextEngine); ok {
+		return c.reconstructContext(ctx, frags, fragIndex)
+	}
+	return reconstructColumns(ctx, impl, backend.Params, frags, fragIndex)
+}
+
+// EncodeSegmentsContext encodes data in segments of segmentSize bytes
+// (DefaultSegmentSize if zero), checking ctx before each, so cancellation
+// takes effect between segments even with liberasurecode. segs[i] holds the
+// K+M fragments of segment i, so segs[i][j] is the i'th fragment of archive
+// j in the layout an ECWriter with that SegmentSize writes. Empty data
+// gives no segments, as with ECWriter.
+func (backend *Backend) EncodeSegmentsContext(ctx context.Context, data []byte, segmentSize int) ([][][]byte, error) {
+	if segmentSize < 0 {
+		return nil, fmt.Errorf("invalid segment size %d: %w", segmentSize, ErrInvalidParams)
+	} else if segmentSize == 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	var segs [][][]byte
+	for len(data) > 0 {
+		n := segmentSize
+		if n > len(data) {
+			n = len(data)
+		}
+		frags, err := backend.EncodeContext(ctx, data[:n])
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, frags)
+		data = data[n:]
+	}
+	return segs, nil
+}
+
+// DecodeSegmentsContext decodes each segment's fragments in turn, checking
+// ctx before each, and returns the data they hold, concatenated. It undoes
+// EncodeSegmentsContext, though each segment needs only K of its
+// fragments.
+func (backend *Backend) DecodeSegmentsContext(ctx context.Context, segs [][][]byte) ([]byte, error) {
+	var data []byte
+	for i, frags := range segs {
+		seg, err := backend.DecodeContext(ctx, frags)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		data = append(data, seg...)
+	}
+	return data, nil
+}
+
+// ReconstructSegmentsContext rebuilds fragment fragIndex of each segment in
+// turn, checking ctx before each, returning the contents of the archive
+// that would hold them.
+func (backend *Backend) ReconstructSegmentsContext(ctx context.Context, segs [][][]byte, fragIndex int) ([][]byte, error) {
+	archive := make([][]byte, len(segs))
+	for i, frags := range segs {
+		frag, err := backend.ReconstructContext(ctx, frags, fragIndex)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		archive[i] = frag
+	}
+	return archive, nil
+}
+
+// encodeContext encodes data with coder, using EncodeContext if coder
+// provides it.
+func encodeContext(ctx context.Context, coder Coder, data []byte) ([][]byte, error) {
+	if c, ok := coder.(interface {
+		EncodeContext(ctx context.Context, data []byte) ([][]byte, error)
+	}); ok {
+		return c.EncodeContext(ctx, data)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return coder.Encode(data)
+}
@@ -0,0 +1,298 @@
+package erasurecode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// countdownContext is done once Err has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n <= 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+func TestContextVariants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, group := range validParamGroups {
+		for _, params := range group.params {
+			if !BackendIsAvailable(params.Name) {
+				continue
+			}
+			backend, err := InitBackend(params)
+			if err != nil {
+				t.Fatalf("Error creating backend %v: %q", params, err)
+			}
+			pattern := testPatterns[5]
+			frags, err := backend.EncodeContext(context.Background(), pattern)
+			if err != nil {
+				t.Fatalf("%v: Error encoding: %v", params, err)
+			}
+			data, err := backend.DecodeContext(context.Background(), frags[params.M:])
+			if err != nil || !bytes.Equal(data, pattern) {
+				t.Errorf("%v: Error decoding: %v", params, err)
+			}
+			frag, err := backend.ReconstructContext(context.Background(), frags[1:], 0)
+			if err != nil || !bytes.Equal(frag, frags[0]) {
+				t.Errorf("%v: Error reconstructing: %v", params, err)
+			}
+
+			if _, err := backend.EncodeContext(ctx, pattern); !errors.Is(err, context.Canceled) {
+				t.Errorf("%v: Expected context.Canceled encoding, got %v", params, err)
+			}
+			if _, err := backend.DecodeContext(ctx, frags); !errors.Is(err, context.Canceled) {
+				t.Errorf("%v: Expected context.Canceled decoding, got %v", params, err)
+			}
+			if _, err := backend.ReconstructContext(ctx, frags[1:], 0); !errors.Is(err, context.Canceled) {
+				t.Errorf("%v: Expected context.Canceled reconstructing, got %v", params, err)
+			}
+			backend.Close()
+		}
+	}
+}
+
+func TestPureGoCancellation(t *testing.T) {
+	// Blocks of 256KiB take several chunks; cancel after the first.
+	backend := initGoBackend(t, Params{Name: "isa_l_rs_vand", K: 4, M: 2})
+	pattern := testPatterns[5]
+	if _, err := backend.EncodeContext(&countdownContext{context.Background(), 2}, pattern); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled part way through encoding, got %v", err)
+	}
+	frags, err := backend.Encode(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.DecodeContext(&countdownContext{context.Background(), 2}, frags[2:]); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled part way through decoding, got %v", err)
+	}
+	if _, err := backend.ReconstructContext(&countdownContext{context.Background(), 2}, frags[1:], 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled part way through reconstructing, got %v", err)
+	}
+}
+
+func TestColumnsContext(t *testing.T) {
+	data := make([]byte, 1<<20+12345)
+	rand.Read(data)
+	for _, params := range []Params{
+		{Name: "liberasurecode_rs_vand", K: 4, M: 2},
+		{Name: "isa_l_rs_vand", K: 3, M: 3, ChecksumType: ChecksumNone},
+		{Name: "isa_l_rs_vand", K: 10, M: 4, CRCVariant: CRCLegacy},
+	} {
+		goBackend := initGoBackend(t, params)
+		// Hiding the engine's contextEngine methods leaves each call
+		// uninterruptible, as with liberasurecode, so large operations are
+		// split into several.
+		backend := newBackend(params, struct{ engine }{goBackend.current()})
+		want, err := backend.Encode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frags, err := backend.EncodeContext(context.Background(), data)
+		if err != nil {
+			t.Fatalf("%v: Error encoding: %v", params, err)
+		}
+		for i := range frags {
+			if !bytes.Equal(frags[i], want[i]) {
+				t.Errorf("%v: frag %d differs from Encode's", params, i)
+			}
+		}
+		decoded, err := backend.DecodeContext(context.Background(), frags[params.M:])
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%v: Error decoding: %v", params, err)
+		}
+		for _, index := range []int{0, params.K + params.M - 1} {
+			others := append(append([][]byte{}, frags[:index]...), frags[index+1:]...)
+			frag, err := backend.ReconstructContext(context.Background(), others, index)
+			if err != nil || !bytes.Equal(frag, frags[index]) {
+				t.Errorf("%v: Error reconstructing frag %d: %v", params, index, err)
+			}
+		}
+
+		// Cancellation takes effect between calls.
+		if _, err := backend.EncodeContext(&countdownContext{context.Background(), 2}, data); !errors.Is(err, context.Canceled) {
+			t.Errorf("%v: Expected context.Canceled part way through encoding, got %v", params, err)
+		}
+		if _, err := backend.DecodeContext(&countdownContext{context.Background(), 2}, frags[params.M:]); !errors.Is(err, context.Canceled) {
+			t.Errorf("%v: Expected context.Canceled part way through decoding, got %v", params, err)
+		}
+		if _, err := backend.ReconstructContext(&countdownContext{context.Background(), 2}, frags[1:], 0); !errors.Is(err, context.Canceled) {
+			t.Errorf("%v: Expected context.Canceled part way through reconstructing, got %v", params, err)
+		}
+	}
+}
+
+func TestSegmentsContext(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	goBackend := initGoBackend(t, params)
+	// Hiding the engine's contextEngine methods makes each segment a single
+	// uninterruptible call, as with liberasurecode.
+	backend := newBackend(params, struct{ engine }{goBackend.current()})
+	data := bytes.Repeat([]byte{0xa5, 0x5a, 0x01}, 1000)
+
+	segs, err := backend.EncodeSegmentsContext(context.Background(), data, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 3 {
+		t.Fatalf("Expected 3 segments, got %d", len(segs))
+	}
+	for i, frags := range segs {
+		want, err := backend.Encode(data[i*1000 : (i+1)*1000])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range frags {
+			if !bytes.Equal(frags[j], want[j]) {
+				t.Errorf("Segment %d, frag %d differs from Encode's", i, j)
+			}
+		}
+	}
+	decoded, err := backend.DecodeSegmentsContext(context.Background(), segs)
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("Segments did not round-trip (%v)", err)
+	}
+	archive, err := backend.ReconstructSegmentsContext(context.Background(), segs, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, frag := range archive {
+		if !bytes.Equal(frag, segs[i][5]) {
+			t.Errorf("Reconstructed frag of segment %d differs", i)
+		}
+	}
+
+	// Cancellation takes effect between segments.
+	if _, err := backend.EncodeSegmentsContext(&countdownContext{context.Background(), 2}, data, 1000); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled encoding the third segment, got %v", err)
+	}
+	if _, err := backend.DecodeSegmentsContext(&countdownContext{context.Background(), 2}, segs); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled decoding the third segment, got %v", err)
+	}
+	if _, err := backend.ReconstructSegmentsContext(&countdownContext{context.Background(), 2}, segs, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled reconstructing the third segment, got %v", err)
+	}
+	if segs, err := backend.EncodeSegmentsContext(context.Background(), nil, 0); err != nil || len(segs) != 0 {
+		t.Errorf("Expected no segments for no data, got %d (%v)", len(segs), err)
+	}
+}
+
+func TestFileWriterContext(t *testing.T) {
+	base := tempDir()
+	defer os.RemoveAll(base)
+	params := Params{Name: "liberasurecode_rs_vand", K: 2, M: 1}
+	backend := initGoBackend(t, params)
+	exists := func(prefix string) (found int) {
+		for i := 0; i < 3; i++ {
+			if _, err := os.Stat(fmt.Sprintf("%s#%d", prefix, i)); err == nil {
+				found++
+			}
+		}
+		return
+	}
+
+	// Large writes are split into segments
+	writer, err := NewFileWriterContext(context.Background(), &backend, base+"ctx_ok", 0640)
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	data := bytes.Repeat([]byte("x"), 2*DefaultSegmentSize+1)
+	if n, err := writer.Write(data); err != nil || n != len(data) {
+		t.Errorf("Expected to write %d bytes, wrote %d (%v)", len(data), n, err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Errorf("Error closing writer: %v", err)
+	}
+	fd, err := os.Open(base + "ctx_ok#0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := 0
+	for {
+		if _, err := ReadFragment(fd); err != nil {
+			break
+		}
+		segments++
+	}
+	fd.Close()
+	if segments != 3 {
+		t.Errorf("Expected 3 segments, got %d", segments)
+	}
+
+	// Small writes are buffered into segments
+	writer, err = NewFileWriterContext(context.Background(), &backend, base+"ctx_small", 0640)
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := writer.Write([]byte("x")); err != nil {
+			t.Fatalf("Error writing: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Errorf("Error closing writer: %v", err)
+	}
+	expected, err := backend.ArchiveSize(10, DefaultSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(base + "ctx_small#0"); err != nil {
+		t.Error(err)
+	} else if info.Size() != expected {
+		t.Errorf("Expected archive of %d bytes, got %d", expected, info.Size())
+	}
+
+	// Cancellation removes what was written
+	ctx, cancel := context.WithCancel(context.Background())
+	writer, err = NewFileWriterContext(ctx, &backend, base+"ctx_cancel", 0640)
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	if _, err := writer.Write([]byte("some data")); err != nil {
+		t.Errorf("Error writing: %v", err)
+	}
+	if found := exists(base + "ctx_cancel"); found != 3 {
+		t.Errorf("Expected 3 archives, found %d", found)
+	}
+	cancel()
+	if _, err := writer.Write([]byte("more data")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if found := exists(base + "ctx_cancel"); found != 0 {
+		t.Errorf("Expected archives to be removed, found %d", found)
+	}
+	if err := writer.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// Cancellation takes effect part way through encoding a segment
+	writers := make([]io.WriteCloser, params.K+params.M)
+	for i := range writers {
+		writers[i] = &memArchive{}
+	}
+	ecWriter := &ECWriter{Backend: &backend, Writers: writers, SegmentSize: 1 << 20,
+		ctx: &countdownContext{context.Background(), 2}}
+	if _, err := ecWriter.Write(testPatterns[5]); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled encoding, got %v", err)
+	}
+
+	if _, err := NewFileWriterContext(ctx, &backend, base+"ctx_never", 0640); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if found := exists(base + "ctx_never"); found != 0 {
+		t.Errorf("Expected no archives, found %d", found)
+	}
+}
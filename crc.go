@@ -0,0 +1,20 @@
+package erasurecode
+
+import (
+	"hash/crc32"
+)
+
+// legacyCRC32 is liberasurecode's original (pre-1.6.0) CRC routine; see
+// https://bugs.launchpad.net/liberasurecode/+bug/1666320
+//
+// It was written with a signed accumulator, so each right-shift drags the
+// sign bit along. Fragments written by older versions of liberasurecode (or
+// newer ones run with LIBERASURECODE_WRITE_LEGACY_CRC set) use it for both
+// metadata and payload checksums.
+func legacyCRC32(buf []byte) uint32 {
+	crc := int32(-1)
+	for _, b := range buf {
+		crc = int32(crc32.IEEETable[byte(crc)^b]) ^ (crc >> 8)
+	}
+	return uint32(^crc)
+}
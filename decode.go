@@ -0,0 +1,292 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DecodeOptions controls how Backend.DecodeWithOptions screens fragments
+// before handing them to liberasurecode.
+type DecodeOptions struct {
+	// SkipMetadataChecks trusts fragment headers as-is: no header or
+	// payload checksums are verified, and liberasurecode is called with
+	// force_metadata_checks disabled.
+	SkipMetadataChecks bool
+	// AllowPayloadOnly accepts fragments whose header fails its metadata
+	// checksum, so long as the payload still matches its CRC32 and the
+	// header's sizes and backend agree with the other fragments. Note that
+	// the fragment index cannot be verified this way; a fragment with a
+	// corrupted index will still be placed at the wrong position.
+	AllowPayloadOnly bool
+}
+
+// RejectedFragment describes a fragment that DecodeWithOptions declined
+// to use.
+type RejectedFragment struct {
+	Position int   // position in the slice passed to DecodeWithOptions
+	Index    int   // fragment index from its header, or -1 if unreadable
+	Err      error // why it was rejected
+}
+
+// DecodeResult is the outcome of Backend.DecodeWithOptions.
+type DecodeResult struct {
+	Data []byte
+	// Used lists the indexes of the fragments passed on to liberasurecode.
+	Used []int
+	// PayloadOnly lists the subset of Used whose headers failed their
+	// metadata checksum; see DecodeOptions.AllowPayloadOnly.
+	PayloadOnly []int
+	// Rejected lists fragments that were not used because they were
+	// invalid. Valid fragments that simply weren't needed are in neither
+	// Used nor Rejected.
+	Rejected []RejectedFragment
+	// Suspects lists the indexes of fragments that passed every check but
+	// disagree with the others; see Backend.DecodeVerified. They are also
+	// listed in Rejected.
+	Suspects []int
+}
+
+// DecodeWithOptions screens frags, picks a minimal set of usable fragments
+// and decodes them. Unlike Decode, which passes every fragment straight to
+// liberasurecode, it reports exactly which fragments were used and why any
+// others were rejected. The result is populated even when an error is
+// returned, so callers can tell which fragments to replace.
+func (backend *Backend) DecodeWithOptions(frags [][]byte, opts DecodeOptions) (DecodeResult, error) {
+	var result DecodeResult
+	if len(frags) == 0 {
+		return result, fmt.Errorf("decoding requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	chosen, payloadOnly := backend.screen(frags, opts, &result)
+
+	n := backend.K + backend.M
+	var missing []int
+	for index := 0; index < n; index++ {
+		if _, ok := chosen[index]; !ok {
+			missing = append(missing, index)
+		}
+	}
+	needed, err := backend.FragmentsNeeded(nil, missing)
+	if err != nil {
+		return result, err
+	}
+	sort.Ints(needed)
+
+	toDecode := make([][]byte, len(needed))
+	for i, index := range needed {
+		toDecode[i] = frags[chosen[index]]
+		if payloadOnly[index] {
+			result.PayloadOnly = append(result.PayloadOnly, index)
+			// liberasurecode checks every header, whatever
+			// force_metadata_checks says, so hand it a copy whose
+			// metadata checksum has been recomputed.
+			toDecode[i] = append([]byte(nil), toDecode[i]...)
+			setMetadataChecksum(toDecode[i], backend.CRCVariant.legacy())
+		}
+	}
+	result.Used = needed
+	result.Data, err = backend.decode(toDecode, !opts.SkipMetadataChecks)
+	return result, err
+}
+
+// screen checks frags according to opts, recording any it rejects in
+// result. It returns the positions in frags of the usable fragments, by
+// index, and which of those were only accepted thanks to
+// opts.AllowPayloadOnly.
+func (backend *Backend) screen(frags [][]byte, opts DecodeOptions, result *DecodeResult) (map[int]int, map[int]bool) {
+	n := backend.K + backend.M
+	chosen := make(map[int]int, n)
+	payloadOnly := make(map[int]bool)
+	reject := func(position, index int, err error) {
+		result.Rejected = append(result.Rejected, RejectedFragment{position, index, err})
+	}
+	infos := make([]FragmentInfo, len(frags))
+	var reference *FragmentInfo
+	var suspects []int
+	for position, frag := range frags {
+		if len(frag) < FragmentHeaderSize {
+			reject(position, -1, fmt.Errorf("fragment too short for header (%d bytes): %w", len(frag), ErrBadHeader))
+			continue
+		}
+		info := GetFragmentInfo(frag)
+		infos[position] = info
+		if info.Index < 0 || info.Index >= n {
+			reject(position, -1, fmt.Errorf("fragment index %d out of range: %w", info.Index, ErrBadHeader))
+			continue
+		}
+		if !opts.SkipMetadataChecks {
+			if !info.IsValid {
+				if opts.AllowPayloadOnly {
+					suspects = append(suspects, position)
+				} else {
+					reject(position, info.Index, fmt.Errorf("metadata checksum failed: %w", ErrBadHeader))
+				}
+				continue
+			}
+			// A payload checksum that can't be verified (MD5, say) is
+			// taken on trust, as Decode would.
+			if err := backend.VerifyFragment(frag); err != nil && !errors.Is(err, ErrMethodNotImplemented) {
+				reject(position, info.Index, err)
+				continue
+			}
+			if reference == nil {
+				reference = &infos[position]
+			}
+		}
+		if _, ok := chosen[info.Index]; ok {
+			continue // duplicates are harmless, but we only need one
+		}
+		chosen[info.Index] = position
+	}
+
+	for _, position := range suspects {
+		info := infos[position]
+		if reference == nil {
+			reference = &infos[position]
+		}
+		if info.ChecksumType != ChecksumCRC32 {
+			reject(position, info.Index, fmt.Errorf("metadata checksum failed and no payload checksum: %w", ErrBadHeader))
+			continue
+		}
+		if err := verifyPayload(info, frags[position]); err != nil {
+			reject(position, info.Index, err)
+			continue
+		}
+		if info.Size != reference.Size || info.OrigDataSize != reference.OrigDataSize ||
+			info.BackendID != reference.BackendID {
+			reject(position, info.Index, fmt.Errorf("metadata checksum failed and header disagrees with other fragments: %w", ErrBadHeader))
+			continue
+		}
+		if _, ok := chosen[info.Index]; ok {
+			continue
+		}
+		chosen[info.Index] = position
+		payloadOnly[info.Index] = true
+	}
+
+	return chosen, payloadOnly
+}
+
+// DecodeVerified decodes frags like DecodeWithOptions (with default
+// options), but also makes sure the fragments are consistent, to catch
+// corruption that payload checksums miss -- fragments written with
+// ChecksumNone, say. The data is re-encoded and compared with every usable
+// fragment. If any disagree, other sets of K fragments are tried until the
+// result disagrees with few enough fragments that no other decode could do
+// better: with E fragments beyond the K needed, up to E/2 corrupt fragments
+// can be located. They are reported in Suspects.
+//
+// If there are too many disagreements to be sure which fragments are at
+// fault (always the case with E < 2), an error wrapping ErrBadChecksum is
+// returned. Locating corrupt fragments may mean trying many sets, so is
+// slow for large K and M.
+func (backend *Backend) DecodeVerified(frags [][]byte) (DecodeResult, error) {
+	var result DecodeResult
+	if len(frags) == 0 {
+		return result, fmt.Errorf("decoding requires at least one fragment: %w", ErrInsufficientFragments)
+	}
+	chosen, _ := backend.screen(frags, DecodeOptions{}, &result)
+	available := make([]int, 0, len(chosen))
+	for index := range chosen {
+		available = append(available, index)
+	}
+	sort.Ints(available)
+	if len(available) < backend.K {
+		return result, fmt.Errorf("%d usable fragments, but %d needed: %w",
+			len(available), backend.K, ErrInsufficientFragments)
+	}
+
+	// Leave out each combination of the extra fragments in turn. Two
+	// decodes can agree on at most K-1 fragments, so one disagreeing with
+	// at most half of the extras must be the best there is.
+	extra := len(available) - backend.K
+	var best *DecodeResult
+	var lastErr error
+	combinations(len(available), extra, func(leaveOut []int) bool {
+		use := make([]int, 0, backend.K)
+		for i, j := 0, 0; i < len(available); i++ {
+			if j < len(leaveOut) && leaveOut[j] == i {
+				j++
+				continue
+			}
+			use = append(use, available[i])
+		}
+		toDecode := make([][]byte, len(use))
+		for i, index := range use {
+			toDecode[i] = frags[chosen[index]]
+		}
+		data, err := backend.decode(toDecode, true)
+		if err != nil {
+			lastErr = err
+			return true
+		}
+		suspects, err := backend.disagreeing(data, frags, chosen, available)
+		if err != nil {
+			lastErr = err
+			return true
+		}
+		if best == nil || len(suspects) < len(best.Suspects) {
+			best = &DecodeResult{Data: data, Used: use, Suspects: suspects}
+		}
+		return 2*len(suspects) > extra
+	})
+	if best == nil {
+		return result, lastErr
+	}
+	if 2*len(best.Suspects) > extra {
+		return result, fmt.Errorf("fragments disagree, but too few to tell which are corrupt: %w", ErrBadChecksum)
+	}
+	result.Data, result.Used, result.Suspects = best.Data, best.Used, best.Suspects
+	for _, index := range result.Suspects {
+		result.Rejected = append(result.Rejected, RejectedFragment{chosen[index], index,
+			fmt.Errorf("fragment %d disagrees with the others: %w", index, ErrBadChecksum)})
+	}
+	return result, nil
+}
+
+// disagreeing re-encodes data and returns the indexes of the available
+// fragments whose payloads differ from the result.
+func (backend *Backend) disagreeing(data []byte, frags [][]byte, chosen map[int]int, available []int) ([]int, error) {
+	encoded, err := backend.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	var suspects []int
+	for _, index := range available {
+		want, err := FragmentPayload(encoded[index])
+		if err != nil {
+			return nil, err
+		}
+		got, err := FragmentPayload(frags[chosen[index]])
+		if err != nil || !bytes.Equal(got, want) {
+			suspects = append(suspects, index)
+		}
+	}
+	return suspects, nil
+}
+
+// combinations calls fn with each r-element subset of [0, n), in
+// lexicographic order, until fn returns false.
+func combinations(n, r int, fn func([]int) bool) {
+	combo := make([]int, r)
+	for i := range combo {
+		combo[i] = i
+	}
+	for {
+		if !fn(combo) {
+			return
+		}
+		i := r - 1
+		for i >= 0 && combo[i] == n-r+i {
+			i--
+		}
+		if i < 0 {
+			return
+		}
+		combo[i]++
+		for j := i + 1; j < r; j++ {
+			combo[j] = combo[j-1] + 1
+		}
+	}
+}
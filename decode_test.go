@@ -0,0 +1,263 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeWithOptions(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend, err := InitBackend(params)
+	if err != nil {
+		t.Fatalf("Error creating backend %v: %q", params, err)
+	}
+	defer backend.Close()
+
+	pattern := testPatterns[7]
+	encode := func() [][]byte {
+		frags, err := backend.Encode(pattern)
+		if err != nil {
+			t.Fatalf("Error encoding: %q", err)
+		}
+		return frags
+	}
+	check := func(description string, result DecodeResult, err error, used, payloadOnly []int, rejected map[int]error) {
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", description, err)
+			return
+		}
+		if !bytes.Equal(result.Data, pattern) {
+			t.Errorf("%v: decoded data does not match", description)
+		}
+		if !reflect.DeepEqual(result.Used, used) {
+			t.Errorf("%v: expected to use %v, used %v", description, used, result.Used)
+		}
+		if !reflect.DeepEqual(result.PayloadOnly, payloadOnly) {
+			t.Errorf("%v: expected payload-only %v, got %v", description, payloadOnly, result.PayloadOnly)
+		}
+		if len(result.Rejected) != len(rejected) {
+			t.Errorf("%v: expected %d rejections, got %v", description, len(rejected), result.Rejected)
+		}
+		for _, r := range result.Rejected {
+			if want, ok := rejected[r.Position]; !ok || !errors.Is(r.Err, want) {
+				t.Errorf("%v: unexpected rejection %v", description, r)
+			}
+		}
+	}
+
+	frags := encode()
+	result, err := backend.DecodeWithOptions(frags, DecodeOptions{})
+	check("all frags", result, err, []int{0, 1, 2, 3}, nil, nil)
+
+	result, err = backend.DecodeWithOptions(shuf(frags[2:]), DecodeOptions{})
+	check("shuffled parity", result, err, []int{2, 3, 4, 5}, nil, nil)
+
+	result, err = backend.DecodeWithOptions(frags, DecodeOptions{SkipMetadataChecks: true})
+	check("skip checks", result, err, []int{0, 1, 2, 3}, nil, nil)
+
+	// Corrupt a payload
+	frags[0][FragmentHeaderSize+10] ^= 0xff
+	result, err = backend.DecodeWithOptions(frags, DecodeOptions{})
+	check("bad payload", result, err, []int{1, 2, 3, 4}, nil, map[int]error{0: ErrBadChecksum})
+	result, err = backend.DecodeWithOptions(frags, DecodeOptions{AllowPayloadOnly: true})
+	check("bad payload, allowing payload-only", result, err, []int{1, 2, 3, 4}, nil, map[int]error{0: ErrBadChecksum})
+
+	// Corrupt a metadata checksum, leaving the payload intact
+	frags = encode()
+	frags[1][68] ^= 0xff
+	result, err = backend.DecodeWithOptions(frags, DecodeOptions{})
+	check("bad header", result, err, []int{0, 2, 3, 4}, nil, map[int]error{1: ErrBadHeader})
+	result, err = backend.DecodeWithOptions(frags[:4], DecodeOptions{AllowPayloadOnly: true})
+	check("bad header, allowing payload-only", result, err, []int{0, 1, 2, 3}, []int{1}, nil)
+
+	// Bad header *and* bad payload can't be trusted
+	frags[1][FragmentHeaderSize] ^= 0xff
+	result, err = backend.DecodeWithOptions(frags, DecodeOptions{AllowPayloadOnly: true})
+	check("bad header and payload", result, err, []int{0, 2, 3, 4}, nil, map[int]error{1: ErrBadChecksum})
+
+	// Too many bad frags
+	frags = encode()
+	for _, frag := range frags[:3] {
+		frag[FragmentHeaderSize] ^= 0xff
+	}
+	frags = append(frags, frags[5][:40])
+	result, err = backend.DecodeWithOptions(frags, DecodeOptions{})
+	if !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+	if len(result.Rejected) != 4 || result.Rejected[3].Index != -1 || result.Rejected[3].Position != 6 {
+		t.Errorf("Expected 4 rejections, got %v", result.Rejected)
+	}
+
+	if _, err := backend.DecodeWithOptions(nil, DecodeOptions{}); !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+}
+
+// strictHeaderEngine refuses to decode fragments with a bad metadata
+// checksum whatever forceMetadataChecks says, as liberasurecode does.
+type strictHeaderEngine struct {
+	engine
+	params Params
+}
+
+func (e strictHeaderEngine) decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	for _, frag := range frags {
+		if !isValidHeader(frag) {
+			return nil, newError("decode", e.params, -errnoEBADHEADER)
+		}
+	}
+	return e.engine.decode(frags, forceMetadataChecks)
+}
+
+func TestDecodePayloadOnlyHeaders(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	goBackend := initGoBackend(t, params)
+	backends := map[string]Backend{
+		"strict": newBackend(params, strictHeaderEngine{goBackend.current(), params}),
+	}
+	if id, _ := nameToID(params.Name); libecBackendAvailable(id) {
+		backend, err := InitBackend(params)
+		if err != nil {
+			t.Fatalf("Error creating backend %v: %v", params, err)
+		}
+		defer backend.Close()
+		backends["liberasurecode"] = backend
+	}
+	for impl, backend := range backends {
+		frags, err := backend.Encode(testPatterns[7])
+		if err != nil {
+			t.Fatalf("%v: Error encoding: %v", impl, err)
+		}
+		frags[1][68] ^= 0xff
+		corrupt := append([]byte(nil), frags[1]...)
+		result, err := backend.DecodeWithOptions(frags[:4], DecodeOptions{AllowPayloadOnly: true})
+		if err != nil || !bytes.Equal(result.Data, testPatterns[7]) {
+			t.Errorf("%v: Error decoding with a payload-only fragment: %v", impl, err)
+		}
+		if !reflect.DeepEqual(result.PayloadOnly, []int{1}) {
+			t.Errorf("%v: Expected payload-only [1], got %v", impl, result.PayloadOnly)
+		}
+		if !bytes.Equal(frags[1], corrupt) {
+			t.Errorf("%v: Caller's fragment was modified", impl)
+		}
+	}
+}
+
+func TestDecodeChecksumTypes(t *testing.T) {
+	for _, checksum := range []ChecksumType{ChecksumNone, ChecksumMD5} {
+		params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2, ChecksumType: checksum}
+		backend := initGoBackend(t, params)
+		frags, err := backend.Encode(testPatterns[7])
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := backend.DecodeWithOptions(frags, DecodeOptions{})
+		if err != nil || !bytes.Equal(result.Data, testPatterns[7]) || len(result.Rejected) > 0 {
+			t.Errorf("%v: Unexpected result %+v (%v)", checksum, result.Rejected, err)
+		}
+		result, err = backend.DecodeVerified(frags)
+		if err != nil || !bytes.Equal(result.Data, testPatterns[7]) || len(result.Rejected) > 0 {
+			t.Errorf("%v: Unexpected verified result %+v (%v)", checksum, result.Rejected, err)
+		}
+	}
+}
+
+func TestDecodeVerified(t *testing.T) {
+	for _, group := range validParamGroups {
+		for _, params := range group.params {
+			if !BackendIsAvailable(params.Name) {
+				continue
+			}
+			params.ChecksumType = ChecksumNone
+			backend, err := InitBackend(params)
+			if err != nil {
+				t.Fatalf("Error creating backend %v: %q", params, err)
+			}
+			pattern := testPatterns[7]
+			frags, err := backend.Encode(pattern)
+			if err != nil {
+				t.Fatalf("%v: Error encoding: %v", params, err)
+			}
+
+			result, err := backend.DecodeVerified(shuf(frags))
+			if err != nil || !bytes.Equal(result.Data, pattern) {
+				t.Errorf("%v: Error decoding clean fragments: %v", params, err)
+			}
+			if len(result.Used) != params.K || len(result.Suspects) != 0 || len(result.Rejected) != 0 {
+				t.Errorf("%v: Unexpected result for clean fragments %+v", params, result)
+			}
+
+			// Corrupt the payload of one fragment; with no checksum to
+			// catch it, only the other fragments can.
+			bad := params.K - 1
+			frags[bad] = append([]byte{}, frags[bad]...)
+			frags[bad][FragmentHeaderSize] ^= 0xff
+			result, err = backend.DecodeVerified(frags)
+			if params.M < 2 {
+				if !errors.Is(err, ErrBadChecksum) {
+					t.Errorf("%v: Expected ErrBadChecksum, got %v", params, err)
+				}
+			} else if err != nil {
+				t.Errorf("%v: Error decoding with corrupt fragment: %v", params, err)
+			} else {
+				if !bytes.Equal(result.Data, pattern) {
+					t.Errorf("%v: Decoded data does not match", params)
+				}
+				if !reflect.DeepEqual(result.Suspects, []int{bad}) {
+					t.Errorf("%v: Expected suspects [%d], got %v", params, bad, result.Suspects)
+				}
+				if len(result.Rejected) != 1 || result.Rejected[0].Position != bad ||
+					!errors.Is(result.Rejected[0].Err, ErrBadChecksum) {
+					t.Errorf("%v: Unexpected rejections %+v", params, result.Rejected)
+				}
+				for _, index := range result.Used {
+					if index == bad {
+						t.Errorf("%v: Used corrupt fragment", params)
+					}
+				}
+			}
+			backend.Close()
+		}
+	}
+
+	// With payload checksums, corruption is caught up front.
+	backend := initGoBackend(t, Params{Name: "isa_l_rs_vand", K: 4, M: 2})
+	frags, err := backend.Encode(testPatterns[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags[0][FragmentHeaderSize] ^= 0xff
+	result, err := backend.DecodeVerified(frags)
+	if err != nil || !bytes.Equal(result.Data, testPatterns[7]) {
+		t.Errorf("Error decoding: %v", err)
+	}
+	if len(result.Suspects) != 0 || len(result.Rejected) != 1 || !errors.Is(result.Rejected[0].Err, ErrBadChecksum) {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if _, err := backend.DecodeVerified(frags[:3]); !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+}
+
+func TestCombinations(t *testing.T) {
+	var got [][]int
+	combinations(4, 2, func(combo []int) bool {
+		got = append(got, append([]int{}, combo...))
+		return true
+	})
+	want := [][]int{{0, 1}, {0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	calls := 0
+	combinations(3, 0, func(combo []int) bool {
+		calls++
+		return true
+	})
+	if calls != 1 {
+		t.Errorf("Expected one empty combination, got %d", calls)
+	}
+}
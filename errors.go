@@ -0,0 +1,137 @@
+package erasurecode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors for the failures liberasurecode can report. Every error
+// returned from a liberasurecode call wraps one of these, so callers can
+// use errors.Is rather than matching on error strings.
+var (
+	ErrBackendNotSupported   = errors.New("backend not supported")        // EBACKENDNOTSUPP
+	ErrMethodNotImplemented  = errors.New("method not implemented")       // EECMETHODNOTIMPL
+	ErrBackendInitError      = errors.New("backend initialization error") // EBACKENDINITERR
+	ErrBackendInUse          = errors.New("backend in use")               // EBACKENDINUSE
+	ErrBackendNotAvailable   = errors.New("backend not available")        // EBACKENDNOTAVAIL
+	ErrBadChecksum           = errors.New("bad checksum")                 // EBADCHKSUM
+	ErrInvalidParams         = errors.New("invalid parameters")           // EINVALIDPARAMS
+	ErrBadHeader             = errors.New("bad fragment header")          // EBADHEADER
+	ErrInsufficientFragments = errors.New("insufficient fragments")       // EINSUFFFRAGS
+	ErrBackendFailure        = errors.New("backend operation failed")     // EPERM; backends often just return -1
+	ErrOutOfMemory           = errors.New("out of memory")                // ENOMEM
+	ErrInvalidArgument       = errors.New("invalid argument")             // EINVAL
+	ErrUnknown               = errors.New("unknown liberasurecode error") // anything else
+)
+
+// Error describes a failed liberasurecode call. It wraps one of the
+// sentinel errors above, so
+//
+//	errors.Is(err, ErrInsufficientFragments)
+//
+// works, while errors.As may be used to get at the details.
+type Error struct {
+	Op      string // the liberasurecode function that failed, e.g. "decode"
+	Backend string // the backend name
+	Errno   int    // the (positive) error code returned
+	Params  Params // the parameters the backend was created with
+	Err     error  // the matching sentinel error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s() returned %s", e.Op, errnoName(e.Errno))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrBackendClosed is returned by calls on a Backend that has been closed,
+// or is being closed. It also matches ErrBackendNotAvailable, the error
+// liberasurecode gives for a destroyed instance.
+var ErrBackendClosed error = closedError{}
+
+type closedError struct{}
+
+func (closedError) Error() string {
+	return "backend already closed"
+}
+
+func (closedError) Is(target error) bool {
+	return target == ErrBackendNotAvailable
+}
+
+// unsupportedBackendError is returned when asked for a backend name we
+// know nothing about.
+type unsupportedBackendError string
+
+func (e unsupportedBackendError) Error() string {
+	return fmt.Sprintf("unsupported backend %q", string(e))
+}
+
+func (e unsupportedBackendError) Is(target error) bool {
+	return target == ErrBackendNotSupported
+}
+
+// ParamsError reports a field of Params that is invalid for the backend.
+// It matches ErrInvalidParams with errors.Is.
+type ParamsError struct {
+	Params Params
+	Field  string // "K", "M", "W", "HD" or "ChecksumType"
+	Reason string
+}
+
+func (e *ParamsError) Error() string {
+	return fmt.Sprintf("invalid %s for %s backend: %s", e.Field, e.Params.Name, e.Reason)
+}
+
+func (e *ParamsError) Is(target error) bool {
+	return target == ErrInvalidParams
+}
+
+// ErrQuorumNotMet is matched by a WriteError when too few fragment archives
+// remain healthy for the object to be considered durable.
+var ErrQuorumNotMet = errors.New("write quorum not met")
+
+// ArchiveError records why writing to or reading from one fragment archive
+// failed.
+type ArchiveError struct {
+	Index int // the position in ECWriter.Writers or ECReader.Archives
+	Err   error
+}
+
+func (e ArchiveError) Error() string {
+	return fmt.Sprintf("fragment archive #%d: %v", e.Index, e.Err)
+}
+
+func (e ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError reports the fragment archives an ECWriter failed to write,
+// once fewer than Quorum remain healthy. It matches ErrQuorumNotMet with
+// errors.Is.
+type WriteError struct {
+	Failed  []ArchiveError // in order of fragment index
+	Healthy int
+	Quorum  int
+}
+
+func (e *WriteError) Error() string {
+	msg := fmt.Sprintf("%d of %d fragment archives failed", len(e.Failed), len(e.Failed)+e.Healthy)
+	if e.Healthy < e.Quorum {
+		msg += fmt.Sprintf(", leaving fewer than the %d needed", e.Quorum)
+	}
+	for i, failure := range e.Failed {
+		sep := "; "
+		if i == 0 {
+			sep = ": "
+		}
+		msg += fmt.Sprintf("%s#%d: %v", sep, failure.Index, failure.Err)
+	}
+	return msg
+}
+
+func (e *WriteError) Is(target error) bool {
+	return target == ErrQuorumNotMet && e.Healthy < e.Quorum
+}
@@ -0,0 +1,200 @@
+package erasurecode
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// galoisField implements arithmetic in GF(2^w) using log/antilog tables.
+type galoisField struct {
+	w    uint
+	poly int
+	once sync.Once
+	log  []int32
+	exp  []int32 // doubled, so exp[log[a]+log[b]] needs no modulus
+}
+
+// Fields used by the pure-Go codes. Tables are built on first use, as the
+// GF(2^16) ones are a few hundred KiB.
+var (
+	gf8  = &galoisField{w: 8, poly: 0x11d}    // as used by ISA-L
+	gf16 = &galoisField{w: 16, poly: 0x1100b} // as used by liberasurecode's rs_vand
+)
+
+func (f *galoisField) init() {
+	f.once.Do(func() {
+		size := 1 << f.w
+		f.log = make([]int32, size)
+		f.exp = make([]int32, 2*(size-1))
+		x := 1
+		for i := 0; i < size-1; i++ {
+			f.log[x] = int32(i)
+			f.exp[i] = int32(x)
+			f.exp[i+size-1] = int32(x)
+			x <<= 1
+			if x&size != 0 {
+				x ^= f.poly
+			}
+		}
+	})
+}
+
+func (f *galoisField) mul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	f.init()
+	return int(f.exp[f.log[a]+f.log[b]])
+}
+
+// inv returns the multiplicative inverse of a, which must be non-zero.
+func (f *galoisField) inv(a int) int {
+	f.init()
+	return int(f.exp[(1<<f.w)-1-int(f.log[a])])
+}
+
+// mulAdd computes dst ^= c * src over the field, treating the buffers as
+// little-endian w-bit words. Both buffers must be the same length, and a
+// multiple of the word size.
+func (f *galoisField) mulAdd(dst, src []byte, c int) {
+	switch {
+	case c == 0:
+		return
+	case c == 1:
+		for i := range src {
+			dst[i] ^= src[i]
+		}
+		return
+	}
+	if f.w == 8 {
+		var table [256]byte
+		for i := range table {
+			table[i] = byte(f.mul(c, i))
+		}
+		for i, b := range src {
+			dst[i] ^= table[b]
+		}
+		return
+	}
+	// Multiplication distributes over XOR, so split each 16-bit word
+	// into bytes and use two small tables rather than one huge one.
+	var lo, hi [256]uint16
+	for i := range lo {
+		lo[i] = uint16(f.mul(c, i))
+		hi[i] = uint16(f.mul(c, i<<8))
+	}
+	le := binary.LittleEndian
+	for i := 0; i+1 < len(src); i += 2 {
+		word := le.Uint16(src[i:])
+		le.PutUint16(dst[i:], le.Uint16(dst[i:])^lo[word&0xff]^hi[word>>8])
+	}
+}
+
+// invertMatrix inverts the square matrix m in place by Gauss-Jordan
+// elimination, returning false if it is singular.
+func (f *galoisField) invertMatrix(m [][]int) bool {
+	n := len(m)
+	inv := make([][]int, n)
+	for i := range inv {
+		inv[i] = make([]int, n)
+		inv[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+		if scale := m[col][col]; scale != 1 {
+			scale = f.inv(scale)
+			for j := 0; j < n; j++ {
+				m[col][j] = f.mul(m[col][j], scale)
+				inv[col][j] = f.mul(inv[col][j], scale)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row == col || m[row][col] == 0 {
+				continue
+			}
+			factor := m[row][col]
+			for j := 0; j < n; j++ {
+				m[row][j] ^= f.mul(factor, m[col][j])
+				inv[row][j] ^= f.mul(factor, inv[col][j])
+			}
+		}
+	}
+	copy(m, inv)
+	return true
+}
+
+// isaLRSVandMatrix builds the parity rows of ISA-L's gf_gen_rs_matrix:
+// row r is successive powers of 2^r.
+func isaLRSVandMatrix(k, m int) [][]int {
+	parity := make([][]int, m)
+	gen := 1
+	for r := range parity {
+		parity[r] = make([]int, k)
+		p := 1
+		for j := range parity[r] {
+			parity[r][j] = p
+			p = gf8.mul(p, gen)
+		}
+		gen = gf8.mul(gen, 2)
+	}
+	return parity
+}
+
+// liberasurecodeRSVandMatrix builds the parity rows of liberasurecode's
+// built-in rs_vand code, following make_systematic_matrix: start from a
+// (k+m)-by-k Vandermonde matrix, use column operations to make the top k
+// rows the identity, then scale parity columns so the first parity row is
+// all ones.
+func liberasurecodeRSVandMatrix(k, m int) [][]int {
+	f := gf16
+	rows, cols := k+m, k
+	matrix := make([][]int, rows)
+	matrix[0] = make([]int, cols)
+	matrix[0][0] = 1
+	for i := 1; i < rows; i++ {
+		matrix[i] = make([]int, cols)
+		acc := 1
+		for j := 0; j < cols; j++ {
+			matrix[i][j] = acc
+			acc = f.mul(acc, i)
+		}
+	}
+	colMult := func(elem, col, fromRow int) {
+		for i := fromRow; i < rows; i++ {
+			matrix[i][col] = f.mul(matrix[i][col], elem)
+		}
+	}
+	for i := 1; i < cols; i++ {
+		next := i
+		for next < rows && matrix[next][i] == 0 {
+			next++
+		}
+		if next != i && next < rows {
+			matrix[i], matrix[next] = matrix[next], matrix[i]
+		}
+		if matrix[i][i] != 1 {
+			colMult(f.inv(matrix[i][i]), i, 0)
+		}
+		for j := 0; j < cols; j++ {
+			if val := matrix[i][j]; i != j && val != 0 {
+				for r := 0; r < rows; r++ {
+					matrix[r][j] ^= f.mul(matrix[r][i], val)
+				}
+			}
+		}
+	}
+	for i := 0; i < cols; i++ {
+		if val := matrix[cols][i]; val != 1 {
+			colMult(f.inv(val), i, i)
+		}
+	}
+	return matrix[k:]
+}
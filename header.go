@@ -0,0 +1,279 @@
+package erasurecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Layout of liberasurecode's (packed, little-endian) struct fragment_header_s.
+const (
+	// FragmentHeaderSize is the length of the header liberasurecode
+	// prepends to every fragment.
+	FragmentHeaderSize = 80
+
+	fragmentMetadataSize = 59 // sizeof (fragment_metadata_t); covered by the metadata CRC
+	maxChecksumLen       = 8  // LIBERASURECODE_MAX_CHECKSUM_LEN
+	fragmentHeaderMagic  = 0xb0c5ecc
+
+	offIndex               = 0
+	offSize                = 4
+	offBackendMetadataSize = 8
+	offOrigDataSize        = 12
+	offChecksumType        = 20
+	offChecksum            = 21
+	offChecksumMismatch    = 53
+	offBackendID           = 54
+	offBackendVersion      = 55
+	offMagic               = 59
+	offLibecVersion        = 63
+	offMetadataChecksum    = 67
+)
+
+// First liberasurecode version to write metadata checksums (1.2.0), packed
+// the way it's stored in fragment headers.
+const metadataChecksumVersion = 1<<16 | 2<<8 | 0
+
+// fragmentHeader mirrors struct fragment_header_s field for field.
+type fragmentHeader struct {
+	index               uint32
+	size                uint32
+	backendMetadataSize uint32
+	origDataSize        uint64
+	checksumType        uint8
+	checksum            [maxChecksumLen]uint32
+	checksumMismatch    uint8
+	backendID           uint8
+	backendVersion      uint32
+	magic               uint32
+	libecVersion        uint32
+	metadataChecksum    uint32
+}
+
+// parseHeader reads a header from the start of buf, which must be at least
+// FragmentHeaderSize bytes long.
+func parseHeader(buf []byte) fragmentHeader {
+	le := binary.LittleEndian
+	h := fragmentHeader{
+		index:               le.Uint32(buf[offIndex:]),
+		size:                le.Uint32(buf[offSize:]),
+		backendMetadataSize: le.Uint32(buf[offBackendMetadataSize:]),
+		origDataSize:        le.Uint64(buf[offOrigDataSize:]),
+		checksumType:        buf[offChecksumType],
+		checksumMismatch:    buf[offChecksumMismatch],
+		backendID:           buf[offBackendID],
+		backendVersion:      le.Uint32(buf[offBackendVersion:]),
+		magic:               le.Uint32(buf[offMagic:]),
+		libecVersion:        le.Uint32(buf[offLibecVersion:]),
+		metadataChecksum:    le.Uint32(buf[offMetadataChecksum:]),
+	}
+	for i := range h.checksum {
+		h.checksum[i] = le.Uint32(buf[offChecksum+4*i:])
+	}
+	return h
+}
+
+// put writes h to the start of buf, which must be at least
+// FragmentHeaderSize bytes long. The padding is zeroed; the metadata
+// checksum is written as-is.
+func (h fragmentHeader) put(buf []byte) {
+	le := binary.LittleEndian
+	le.PutUint32(buf[offIndex:], h.index)
+	le.PutUint32(buf[offSize:], h.size)
+	le.PutUint32(buf[offBackendMetadataSize:], h.backendMetadataSize)
+	le.PutUint64(buf[offOrigDataSize:], h.origDataSize)
+	buf[offChecksumType] = h.checksumType
+	for i, c := range h.checksum {
+		le.PutUint32(buf[offChecksum+4*i:], c)
+	}
+	buf[offChecksumMismatch] = h.checksumMismatch
+	buf[offBackendID] = h.backendID
+	le.PutUint32(buf[offBackendVersion:], h.backendVersion)
+	le.PutUint32(buf[offMagic:], h.magic)
+	le.PutUint32(buf[offLibecVersion:], h.libecVersion)
+	le.PutUint32(buf[offMetadataChecksum:], h.metadataChecksum)
+	for i := offMetadataChecksum + 4; i < FragmentHeaderSize; i++ {
+		buf[i] = 0
+	}
+}
+
+// setMetadataChecksum computes the metadata CRC over the start of buf
+// (which must already hold the rest of the header) and writes it in place.
+func setMetadataChecksum(buf []byte, legacy bool) {
+	var crc uint32
+	if legacy {
+		crc = legacyCRC32(buf[:fragmentMetadataSize])
+	} else {
+		crc = crc32.ChecksumIEEE(buf[:fragmentMetadataSize])
+	}
+	binary.LittleEndian.PutUint32(buf[offMetadataChecksum:], crc)
+}
+
+// MetadataCRC identifies which CRC routine produced a fragment header's
+// metadata checksum.
+type MetadataCRC uint8
+
+const (
+	// MetadataCRCNone means the header predates metadata checksums
+	// (liberasurecode before 1.2.0) and was taken on faith.
+	MetadataCRCNone MetadataCRC = iota
+	// MetadataCRC32 is the standard (IEEE) CRC32.
+	MetadataCRC32
+	// MetadataCRCLegacy is liberasurecode's original CRC routine, written
+	// when Params.CRCVariant is CRCLegacy, or is unset and
+	// LIBERASURECODE_WRITE_LEGACY_CRC is.
+	MetadataCRCLegacy
+)
+
+func (v MetadataCRC) String() string {
+	switch v {
+	case MetadataCRCNone:
+		return "none"
+	case MetadataCRC32:
+		return "crc32"
+	case MetadataCRCLegacy:
+		return "legacy"
+	default:
+		return fmt.Sprintf("<unknown metadata CRC %d>", uint8(v))
+	}
+}
+
+// VerifyMetadataChecksum checks the metadata checksum of the header at the
+// start of frag, as liberasurecode's is_invalid_fragment_header does, and
+// reports which CRC variant it uses. Either variant is accepted. The error
+// wraps ErrBadHeader.
+func VerifyMetadataChecksum(frag []byte) (MetadataCRC, error) {
+	if len(frag) < FragmentHeaderSize {
+		return MetadataCRCNone, fmt.Errorf("fragment too short for header (%d bytes): %w", len(frag), ErrBadHeader)
+	}
+	h := parseHeader(frag)
+	if h.libecVersion == 0 {
+		return MetadataCRCNone, fmt.Errorf("no liberasurecode version in header: %w", ErrBadHeader)
+	}
+	if h.libecVersion < metadataChecksumVersion {
+		return MetadataCRCNone, nil
+	}
+	if h.magic != fragmentHeaderMagic {
+		return MetadataCRCNone, fmt.Errorf("bad magic number %#x: %w", h.magic, ErrBadHeader)
+	}
+	switch h.metadataChecksum {
+	case crc32.ChecksumIEEE(frag[:fragmentMetadataSize]):
+		return MetadataCRC32, nil
+	case legacyCRC32(frag[:fragmentMetadataSize]):
+		return MetadataCRCLegacy, nil
+	}
+	return MetadataCRCNone, fmt.Errorf("metadata checksum failed: %w", ErrBadHeader)
+}
+
+// isValidHeader reimplements liberasurecode's is_invalid_fragment_header
+// (inverted): headers from versions predating metadata checksums are
+// taken on faith, and either CRC variant is accepted.
+func isValidHeader(buf []byte) bool {
+	_, err := VerifyMetadataChecksum(buf)
+	return err == nil
+}
+
+// setOrigDataSize rewrites the original data size in the header at the
+// start of buf, updating any metadata checksum to match.
+func setOrigDataSize(buf []byte, size uint64) {
+	legacy := hasLegacyMetadataChecksum(buf)
+	binary.LittleEndian.PutUint64(buf[offOrigDataSize:], size)
+	if binary.LittleEndian.Uint32(buf[offLibecVersion:]) >= metadataChecksumVersion {
+		setMetadataChecksum(buf, legacy)
+	}
+}
+
+// checkFragments makes sure frags are safe to hand to liberasurecode,
+// which trusts the sizes in fragment headers: each must hold a full header
+// and the payload it describes, and all must agree on the payload size.
+// When forceMetadataChecks is set, fragments with invalid headers are left
+// for liberasurecode to skip. It returns the length of a fragment without
+// any trailing bytes.
+func checkFragments(op string, params Params, frags [][]byte, forceMetadataChecks bool) (int, error) {
+	size := -1
+	for _, frag := range frags {
+		if len(frag) < FragmentHeaderSize {
+			return 0, newError(op, params, -errnoEBADHEADER)
+		}
+		if forceMetadataChecks && !isValidHeader(frag) {
+			continue
+		}
+		fragSize := int(binary.LittleEndian.Uint32(frag[offSize:]))
+		if len(frag)-FragmentHeaderSize < fragSize || (size >= 0 && fragSize != size) {
+			return 0, newError(op, params, -errnoEBADHEADER)
+		}
+		size = fragSize
+	}
+	if size < 0 {
+		return 0, newError(op, params, -errnoEINSUFFFRAGS)
+	}
+	return FragmentHeaderSize + size, nil
+}
+
+// hasLegacyMetadataChecksum reports whether the header at the start of buf
+// has a metadata checksum from the legacy CRC routine, and not the
+// standard one.
+func hasLegacyMetadataChecksum(buf []byte) bool {
+	crc := binary.LittleEndian.Uint32(buf[offMetadataChecksum:])
+	return crc != crc32.ChecksumIEEE(buf[:fragmentMetadataSize]) &&
+		crc == legacyCRC32(buf[:fragmentMetadataSize])
+}
+
+// MarshalBinary encodes info as a FragmentHeaderSize-byte fragment header.
+// The magic number is always set and the metadata checksum recomputed
+// (with the legacy routine if LegacyMetadataChecksum is set), so a header
+// may be read with UnmarshalBinary, modified, and written back. Headers
+// from liberasurecode versions predating metadata checksums keep
+// MetadataChecksum as-is. IsValid and BackendName are ignored.
+func (info FragmentInfo) MarshalBinary() ([]byte, error) {
+	if info.Index < 0 || info.Size < 0 || info.BackendMetadataSize < 0 {
+		return nil, fmt.Errorf("negative index or size in fragment info: %w", ErrInvalidParams)
+	}
+	h := fragmentHeader{
+		index:               uint32(info.Index),
+		size:                uint32(info.Size),
+		backendMetadataSize: uint32(info.BackendMetadataSize),
+		origDataSize:        info.OrigDataSize,
+		checksumType:        uint8(info.ChecksumType),
+		checksum:            info.Checksum,
+		backendID:           uint8(info.BackendID),
+		backendVersion:      info.BackendVersion.pack(),
+		magic:               fragmentHeaderMagic,
+		libecVersion:        info.ErasureCodeVersion.pack(),
+		metadataChecksum:    info.MetadataChecksum,
+	}
+	if info.ChecksumMismatch {
+		h.checksumMismatch = 1
+	}
+	buf := make([]byte, FragmentHeaderSize)
+	h.put(buf)
+	if h.libecVersion >= metadataChecksumVersion {
+		setMetadataChecksum(buf, info.LegacyMetadataChecksum)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes the fragment header at the start of data, which
+// may be a whole fragment. As with GetFragmentInfo, a header that fails
+// its metadata checksum is still decoded, but with IsValid unset.
+func (info *FragmentInfo) UnmarshalBinary(data []byte) error {
+	if len(data) < FragmentHeaderSize {
+		return fmt.Errorf("fragment too short for header (%d bytes): %w", len(data), ErrBadHeader)
+	}
+	*info = GetFragmentInfo(data)
+	return nil
+}
+
+// FragmentPayload returns the part of frag after its header: the encoded
+// data, and any backend-specific metadata.
+func FragmentPayload(frag []byte) ([]byte, error) {
+	if len(frag) < FragmentHeaderSize {
+		return nil, fmt.Errorf("fragment too short for header (%d bytes): %w", len(frag), ErrBadHeader)
+	}
+	size := int(binary.LittleEndian.Uint32(frag[offSize:]))
+	if len(frag)-FragmentHeaderSize < size {
+		return nil, fmt.Errorf("fragment truncated; expected %d payload bytes, got %d: %w",
+			size, len(frag)-FragmentHeaderSize, ErrBadHeader)
+	}
+	return frag[FragmentHeaderSize : FragmentHeaderSize+size], nil
+}
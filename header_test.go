@@ -0,0 +1,179 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFragmentInfoMarshaling(t *testing.T) {
+	t.Setenv("LIBERASURECODE_WRITE_LEGACY_CRC", "")
+	backend := initGoBackend(t, Params{Name: "liberasurecode_rs_vand", K: 4, M: 2})
+	frags, err := backend.Encode(testPatterns[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, frag := range frags {
+		var info FragmentInfo
+		if err := info.UnmarshalBinary(frag); err != nil {
+			t.Fatalf("Error unmarshaling frag %d: %v", index, err)
+		}
+		if info != GetFragmentInfo(frag) || !info.IsValid || info.LegacyMetadataChecksum {
+			t.Errorf("Unexpected info for frag %d: %+v", index, info)
+		}
+		header, err := info.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Error marshaling frag %d: %v", index, err)
+		}
+		if !bytes.Equal(header, frag[:FragmentHeaderSize]) {
+			t.Errorf("Frag %d header did not round-trip:\n%x\n%x", index, header, frag[:FragmentHeaderSize])
+		}
+		payload, err := FragmentPayload(frag)
+		if err != nil || !bytes.Equal(payload, frag[FragmentHeaderSize:]) {
+			t.Errorf("Unexpected payload for frag %d (%v)", index, err)
+		}
+	}
+
+	// Relabel a fragment; the metadata checksum is updated to match.
+	var info FragmentInfo
+	if err := info.UnmarshalBinary(frags[1]); err != nil {
+		t.Fatal(err)
+	}
+	info.Index = 5
+	header, err := info.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabeled := append(header, frags[1][FragmentHeaderSize:]...)
+	if got := GetFragmentInfo(relabeled); got.Index != 5 || !got.IsValid {
+		t.Errorf("Unexpected relabeled info %+v", got)
+	}
+
+	if err := info.UnmarshalBinary(frags[0][:FragmentHeaderSize-1]); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("Expected ErrBadHeader for short header, got %v", err)
+	}
+	if _, err := FragmentPayload(frags[0][:len(frags[0])-1]); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("Expected ErrBadHeader for truncated payload, got %v", err)
+	}
+	info.Index = -1
+	if _, err := info.MarshalBinary(); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for negative index, got %v", err)
+	}
+}
+
+func TestFragmentInfoLegacyCRC(t *testing.T) {
+	backend := initGoBackend(t, Params{Name: "isa_l_rs_vand", K: 2, M: 1, CRCVariant: CRCLegacy})
+	frags, err := backend.Encode([]byte("abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info FragmentInfo
+	if err := info.UnmarshalBinary(frags[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsValid || !info.LegacyMetadataChecksum {
+		t.Errorf("Expected valid legacy CRC header, got %+v", info)
+	}
+	header, err := info.MarshalBinary()
+	if err != nil || !bytes.Equal(header, frags[0][:FragmentHeaderSize]) {
+		t.Errorf("Legacy CRC header did not round-trip (%v)", err)
+	}
+
+	// Headers from before metadata checksums keep whatever was there.
+	info.ErasureCodeVersion = Version{1, 1, 0}
+	info.MetadataChecksum = 0x12345678
+	header, _ = info.MarshalBinary()
+	if got := GetFragmentInfo(header); got.MetadataChecksum != 0x12345678 || !got.IsValid {
+		t.Errorf("Unexpected old-style header %+v", got)
+	}
+}
+
+func TestVerifyMetadataChecksum(t *testing.T) {
+	t.Setenv("LIBERASURECODE_WRITE_LEGACY_CRC", "")
+	for _, group := range validParamGroups {
+		for _, params := range group.params {
+			if !BackendIsAvailable(params.Name) {
+				continue
+			}
+			for _, variant := range []CRCVariant{CRCDefault, CRCStandard, CRCLegacy} {
+				params.CRCVariant = variant
+				want := MetadataCRC32
+				if variant == CRCLegacy {
+					want = MetadataCRCLegacy
+				}
+				backend, err := InitBackend(params)
+				if err != nil {
+					t.Fatalf("Error creating backend %v: %v", params, err)
+				}
+				frags, err := backend.Encode(testPatterns[2])
+				if err != nil {
+					t.Fatalf("%v: Error encoding: %v", params, err)
+				}
+				frag, err := backend.Reconstruct(frags[1:], 0)
+				if err != nil {
+					t.Fatalf("%v: Error reconstructing: %v", params, err)
+				}
+				for index, frag := range append(frags, frag) {
+					if got, err := VerifyMetadataChecksum(frag); err != nil || got != want {
+						t.Errorf("%v: Expected frag %d to use %v CRC, got %v (%v)", params, index, want, got, err)
+					}
+				}
+				if data, err := backend.Decode(frags[params.M:]); err != nil || !bytes.Equal(data, testPatterns[2]) {
+					t.Errorf("%v: Error decoding: %v", params, err)
+				}
+				backend.Close()
+			}
+		}
+	}
+
+	backend := initGoBackend(t, Params{Name: "liberasurecode_rs_vand", K: 2, M: 1})
+	frags, err := backend.Encode([]byte("abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags[0][offIndex] ^= 1
+	if _, err := VerifyMetadataChecksum(frags[0]); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("Expected ErrBadHeader for corrupt header, got %v", err)
+	}
+	if _, err := VerifyMetadataChecksum(frags[1][:FragmentHeaderSize-1]); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("Expected ErrBadHeader for short header, got %v", err)
+	}
+	copy(frags[1][offLibecVersion:], []byte{0, 1, 1, 0}) // 1.1.0
+	if got, err := VerifyMetadataChecksum(frags[1]); err != nil || got != MetadataCRCNone {
+		t.Errorf("Expected old header to be accepted without checksum, got %v (%v)", got, err)
+	}
+}
+
+func TestCRCVariantOverridesEnv(t *testing.T) {
+	t.Setenv("LIBERASURECODE_WRITE_LEGACY_CRC", "1")
+	inits := map[string]func(Params) Backend{
+		"pure Go": func(params Params) Backend { return initGoBackend(t, params) },
+	}
+	// liberasurecode only honours the environment from 1.6.2.
+	if id, _ := nameToID("liberasurecode_rs_vand"); libecBackendAvailable(id) && !GetVersion().Less(Version{1, 6, 2}) {
+		inits["liberasurecode"] = func(params Params) Backend {
+			backend, err := InitBackend(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return backend
+		}
+	}
+	for name, init := range inits {
+		for variant, want := range map[CRCVariant]MetadataCRC{
+			CRCDefault:  MetadataCRCLegacy,
+			CRCStandard: MetadataCRC32,
+			CRCLegacy:   MetadataCRCLegacy,
+		} {
+			backend := init(Params{Name: "liberasurecode_rs_vand", K: 2, M: 1, CRCVariant: variant})
+			frags, err := backend.Encode([]byte("abcd"))
+			if err != nil {
+				t.Fatalf("%v, %v: Error encoding: %v", name, variant, err)
+			}
+			if got, err := VerifyMetadataChecksum(frags[0]); err != nil || got != want {
+				t.Errorf("%v, %v: Expected %v CRC, got %v (%v)", name, variant, want, got, err)
+			}
+			backend.Close()
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package erasurecode
+
+/*
+#cgo pkg-config: erasurecode-1
+#include <stdlib.h>
+#include <string.h>
+#include <liberasurecode/erasurecode.h>
+#include <liberasurecode/erasurecode_helpers_ext.h>
+// shims to make working with frag arrays easier
+char ** makeStrArray(int n) { return calloc(n, sizeof (char *)); }
+void freeStrArray(char ** arr) { free(arr); }
+void * getStrArrayItem(char ** arr, int idx) { return arr[idx]; }
+void setStrArrayItem(char ** arr, int idx, unsigned char * val) { arr[idx] = (char *) val; }
+*/
+import "C"
+
+import (
+	"math"
+	"runtime"
+	"unsafe"
+)
+
+// libecLinked is true when built against liberasurecode.
+const libecLinked = true
+
+func GetVersion() Version {
+	return makeVersion(uint32(C.liberasurecode_get_version()))
+}
+
+func libecBackendAvailable(id BackendID) bool {
+	return C.liberasurecode_backend_available(C.ec_backend_id_t(id)) != 0
+}
+
+// libecEngine drives a liberasurecode instance.
+type libecEngine struct {
+	params    Params
+	libecDesc C.int
+}
+
+func newLibecEngine(id BackendID, params Params) (engine, error) {
+	ct := params.ChecksumType
+	if ct == 0 {
+		ct = ChecksumCRC32
+	}
+	desc := C.liberasurecode_instance_create(C.ec_backend_id_t(id), &C.struct_ec_args{
+		k:  C.int(params.K),
+		m:  C.int(params.M),
+		w:  C.int(params.W),
+		hd: C.int(params.HD),
+		ct: C.ec_checksum_type_t(ct),
+	})
+	if desc < 0 {
+		return nil, newError("instance_create", params, int(desc))
+	}
+	return &libecEngine{params, desc}, nil
+}
+
+func (e *libecEngine) close() error {
+	if rc := C.liberasurecode_instance_destroy(e.libecDesc); rc != 0 {
+		return newError("instance_destroy", e.params, int(rc))
+	}
+	e.libecDesc = 0
+	return nil
+}
+
+func (e *libecEngine) encode(data []byte) ([][]byte, error) {
+	frags := make([][]byte, e.params.K+e.params.M)
+	if err := e.encodeTo(data, frags); err != nil {
+		return nil, err
+	}
+	return frags, nil
+}
+
+// encodeTo encodes data into the buffers in dst. liberasurecode always
+// allocates the fragments itself, so they are copied out, but into dst
+// rather than freshly allocated memory.
+func (e *libecEngine) encodeTo(data []byte, dst [][]byte) error {
+	var dataFrags **C.char
+	var parityFrags **C.char
+	var fragLength C.uint64_t
+	if len(data) == 0 {
+		frags, err := encodeEmpty(e)
+		if err != nil {
+			return err
+		}
+		copyFragments(dst, frags)
+		return nil
+	}
+	pData := (*C.char)(unsafe.Pointer(&data[0]))
+	if rc := C.liberasurecode_encode(
+		e.libecDesc, pData, C.uint64_t(len(data)),
+		&dataFrags, &parityFrags, &fragLength); rc != 0 {
+		return newError("encode", e.params, int(rc))
+	}
+	defer C.liberasurecode_encode_cleanup(
+		e.libecDesc, dataFrags, parityFrags)
+	for i := 0; i < e.params.K; i++ {
+		dst[i] = copyFromC(dst[i], C.getStrArrayItem(dataFrags, C.int(i)), int(fragLength))
+	}
+	for i := 0; i < e.params.M; i++ {
+		dst[i+e.params.K] = copyFromC(dst[i+e.params.K], C.getStrArrayItem(parityFrags, C.int(i)), int(fragLength))
+	}
+	for _, frag := range dst {
+		e.fixMetadataChecksum(frag)
+	}
+	return nil
+}
+
+// copyFromC copies n bytes from p into buf's storage, if it is large
+// enough, returning the copy.
+func copyFromC(buf []byte, p unsafe.Pointer, n int) []byte {
+	buf = resize(buf, n)
+	if n > 0 {
+		C.memcpy(unsafe.Pointer(&buf[0]), p, C.size_t(n))
+	}
+	return buf
+}
+
+func (e *libecEngine) decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	return e.decodeTo(frags, forceMetadataChecks, nil)
+}
+
+func (e *libecEngine) decodeTo(frags [][]byte, forceMetadataChecks bool, dst []byte) ([]byte, error) {
+	var data *C.char
+	var dataLength C.uint64_t
+
+	fragLength, err := checkFragments("decode", e.params, frags, forceMetadataChecks)
+	if err != nil {
+		return nil, err
+	}
+	if decodesEmpty(frags, e.params.K, forceMetadataChecks) {
+		// liberasurecode can't produce zero-length output; see encodeEmpty
+		return resize(dst, 0), nil
+	}
+
+	cFrags := C.makeStrArray(C.int(len(frags)))
+	defer C.freeStrArray(cFrags)
+	for index, frag := range frags {
+		C.setStrArrayItem(cFrags, C.int(index), (*C.uchar)(&frag[0]))
+	}
+
+	force := C.int(0)
+	if forceMetadataChecks {
+		force = 1
+	}
+	if rc := C.liberasurecode_decode(
+		e.libecDesc, cFrags, C.int(len(frags)),
+		C.uint64_t(fragLength), force,
+		&data, &dataLength); rc != 0 {
+		return nil, newError("decode", e.params, int(rc))
+	}
+	defer C.liberasurecode_decode_cleanup(e.libecDesc, data)
+	runtime.KeepAlive(frags) // prevent frags from being GC-ed during decode
+	return copyFromC(dst, unsafe.Pointer(data), int(dataLength)), nil
+}
+
+func (e *libecEngine) reconstruct(frags [][]byte, fragIndex int) ([]byte, error) {
+	return e.reconstructTo(frags, fragIndex, nil)
+}
+
+// reconstructTo has liberasurecode write the fragment straight into dst's
+// storage, if it is large enough.
+func (e *libecEngine) reconstructTo(frags [][]byte, fragIndex int, dst []byte) ([]byte, error) {
+	fragLength, err := checkFragments("reconstruct_fragment", e.params, frags, false)
+	if err != nil {
+		return nil, err
+	}
+	data := resize(dst, fragLength)
+	pData := (*C.char)(unsafe.Pointer(&data[0]))
+
+	cFrags := C.makeStrArray(C.int(len(frags)))
+	defer C.freeStrArray(cFrags)
+	for index, frag := range frags {
+		C.setStrArrayItem(cFrags, C.int(index), (*C.uchar)(&frag[0]))
+	}
+
+	if rc := C.liberasurecode_reconstruct_fragment(
+		e.libecDesc, cFrags, C.int(len(frags)),
+		C.uint64_t(fragLength), C.int(fragIndex), pData); rc != 0 {
+		return nil, newError("reconstruct_fragment", e.params, int(rc))
+	}
+	runtime.KeepAlive(frags) // prevent frags from being GC-ed during reconstruct
+	e.fixMetadataChecksum(data)
+	return data, nil
+}
+
+// fixMetadataChecksum rewrites the metadata checksum of a fragment
+// liberasurecode just wrote with the CRC Params.CRCVariant asks for, if it
+// isn't the default. liberasurecode itself only looks at the environment.
+func (e *libecEngine) fixMetadataChecksum(frag []byte) {
+	if e.params.CRCVariant != CRCDefault && parseHeader(frag).libecVersion >= metadataChecksumVersion {
+		setMetadataChecksum(frag, e.params.CRCVariant == CRCLegacy)
+	}
+}
+
+// decodesEmpty reports whether frags include K distinct fragments of a
+// zero-length object.
+func decodesEmpty(frags [][]byte, k int, forceMetadataChecks bool) bool {
+	seen := make(map[uint32]bool, k)
+	for _, frag := range frags {
+		if forceMetadataChecks && !isValidHeader(frag) {
+			continue
+		}
+		h := parseHeader(frag)
+		if h.origDataSize != 0 {
+			return false
+		}
+		seen[h.index] = true
+	}
+	return len(seen) >= k
+}
+
+func (e *libecEngine) alignedDataSize(dataLen int) (int, error) {
+	rc := C.liberasurecode_get_aligned_data_size(e.libecDesc, C.uint64_t(dataLen))
+	if rc < 0 {
+		return 0, newError("get_aligned_data_size", e.params, int(rc))
+	}
+	return int(rc), nil
+}
+
+func (e *libecEngine) minimumEncodeSize() (int, error) {
+	rc := C.liberasurecode_get_minimum_encode_size(e.libecDesc)
+	if rc < 0 {
+		return 0, newError("get_minimum_encode_size", e.params, int(rc))
+	}
+	return int(rc), nil
+}
+
+func (e *libecEngine) fragmentSize(dataLen int) (int, error) {
+	// liberasurecode takes the length as an int; don't let it wrap.
+	if dataLen < 0 || dataLen > math.MaxInt32 {
+		return 0, newError("get_fragment_size", e.params, -errnoEINVALIDPARAMS)
+	}
+	rc := C.liberasurecode_get_fragment_size(e.libecDesc, C.int(dataLen))
+	if rc < 0 {
+		return 0, newError("get_fragment_size", e.params, int(rc))
+	}
+	return int(rc), nil
+}
+
+func (e *libecEngine) fragmentsNeeded(want, exclude []int) ([]int, error) {
+	toCList := func(indexes []int) []C.int {
+		list := make([]C.int, 0, len(indexes)+1)
+		for _, idx := range indexes {
+			list = append(list, C.int(idx))
+		}
+		return append(list, -1)
+	}
+	cWant, cExclude := toCList(want), toCList(exclude)
+	needed := make([]C.int, e.params.K+e.params.M+1)
+	for i := range needed {
+		needed[i] = -1
+	}
+	if rc := C.liberasurecode_fragments_needed(
+		e.libecDesc, &cWant[0], &cExclude[0], &needed[0]); rc != 0 {
+		return nil, newError("fragments_needed", e.params, int(rc))
+	}
+	result := make([]int, 0, e.params.K)
+	for _, idx := range needed {
+		if idx < 0 {
+			break
+		}
+		result = append(result, int(idx))
+	}
+	return result, nil
+}
+
+func (e *libecEngine) isInvalidFragment(frag []byte) bool {
+	if _, err := checkFragments("is_invalid_fragment", e.params, [][]byte{frag}, false); err != nil {
+		return true
+	}
+	pData := (*C.char)(unsafe.Pointer(&frag[0]))
+	return 1 == C.is_invalid_fragment(e.libecDesc, pData)
+}
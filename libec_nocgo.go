@@ -0,0 +1,26 @@
+//go:build !cgo
+// +build !cgo
+
+package erasurecode
+
+// Without cgo there's no liberasurecode; only the pure-Go backends in
+// goEngines are available.
+
+// goLibecVersion is the liberasurecode version whose fragment format the
+// pure-Go backends write.
+var goLibecVersion = Version{1, 6, 2}
+
+// libecLinked is true when built against liberasurecode.
+const libecLinked = false
+
+func GetVersion() Version {
+	return goLibecVersion
+}
+
+func libecBackendAvailable(id BackendID) bool {
+	return false
+}
+
+func newLibecEngine(id BackendID, params Params) (engine, error) {
+	return nil, newError("instance_create", params, -errnoEBACKENDNOTAVAIL)
+}
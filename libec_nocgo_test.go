@@ -0,0 +1,10 @@
+//go:build !cgo
+// +build !cgo
+
+package erasurecode
+
+// Without liberasurecode, only the pure-Go backends are available.
+var requiredBackends = []string{
+	"liberasurecode_rs_vand",
+	"isa_l_rs_vand",
+}
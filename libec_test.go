@@ -0,0 +1,35 @@
+//go:build cgo
+// +build cgo
+
+package erasurecode
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// Backends every liberasurecode install provides.
+var requiredBackends = []string{
+	"null",
+	"flat_xor_hd",
+	"liberasurecode_rs_vand",
+}
+
+func TestLibecFragmentSizeRange(t *testing.T) {
+	if id, _ := nameToID("liberasurecode_rs_vand"); !libecBackendAvailable(id) {
+		t.Skip("liberasurecode_rs_vand unavailable")
+	}
+	backend, err := InitBackend(Params{Name: "liberasurecode_rs_vand", K: 4, M: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	tooBig := int64(math.MaxInt32) + 1
+	if _, err := backend.FragmentSize(int(tooBig)); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for %d bytes, got %v", tooBig, err)
+	}
+	if _, err := backend.ArchiveSize(tooBig, tooBig); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for a %d-byte segment, got %v", tooBig, err)
+	}
+}
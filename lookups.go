@@ -1,84 +1,184 @@
 package erasurecode
 
-/*
-#cgo pkg-config: erasurecode-1
-#include <stdlib.h>
-#include <liberasurecode/erasurecode.h>
-*/
-import "C"
 import "fmt"
 
-func nameToID(name string) (C.ec_backend_id_t, error) {
+// BackendID identifies an erasure code in fragment headers. The values match
+// liberasurecode's ec_backend_id_t.
+type BackendID uint8
+
+const (
+	backendNull BackendID = iota
+	backendJerasureRSVand
+	backendJerasureRSCauchy
+	backendFlatXorHD
+	backendIsaLRSVand
+	backendSHSS
+	backendLiberasurecodeRSVand
+	backendIsaLRSCauchy
+	backendLibphazr
+)
+
+// Error codes liberasurecode functions return (negated). The first few are
+// the usual errno values; the rest are from LIBERASURECODE_ERROR_CODES.
+const (
+	errnoEPERM            = 1
+	errnoENOMEM           = 12
+	errnoEINVAL           = 22
+	errnoEBACKENDNOTSUPP  = 200
+	errnoEECMETHODNOTIMPL = 201
+	errnoEBACKENDINITERR  = 202
+	errnoEBACKENDINUSE    = 203
+	errnoEBACKENDNOTAVAIL = 204
+	errnoEBADCHKSUM       = 205
+	errnoEINVALIDPARAMS   = 206
+	errnoEBADHEADER       = 207
+	errnoEINSUFFFRAGS     = 208
+)
+
+// nameToID looks up a backend by name, including those added with
+// RegisterBackend.
+func nameToID(name string) (BackendID, error) {
+	if id, ok := builtinNameToID(name); ok {
+		return id, nil
+	}
+	if factory, ok := registeredBackend(name); ok {
+		return factory.ID, nil
+	}
+	return 0, unsupportedBackendError(name)
+}
+
+func builtinNameToID(name string) (BackendID, bool) {
 	switch name {
 	case "null":
-		return C.EC_BACKEND_NULL, nil
+		return backendNull, true
 	case "jerasure_rs_vand":
-		return C.EC_BACKEND_JERASURE_RS_VAND, nil
+		return backendJerasureRSVand, true
 	case "jerasure_rs_cauchy":
-		return C.EC_BACKEND_JERASURE_RS_CAUCHY, nil
+		return backendJerasureRSCauchy, true
 	case "flat_xor_hd":
-		return C.EC_BACKEND_FLAT_XOR_HD, nil
+		return backendFlatXorHD, true
 	case "isa_l_rs_vand":
-		return C.EC_BACKEND_ISA_L_RS_VAND, nil
+		return backendIsaLRSVand, true
 	case "shss":
-		return C.EC_BACKEND_SHSS, nil
+		return backendSHSS, true
 	case "liberasurecode_rs_vand":
-		return C.EC_BACKEND_LIBERASURECODE_RS_VAND, nil
+		return backendLiberasurecodeRSVand, true
 	case "isa_l_rs_cauchy":
-		return C.EC_BACKEND_ISA_L_RS_CAUCHY, nil
+		return backendIsaLRSCauchy, true
 	case "libphazr":
-		return C.EC_BACKEND_LIBPHAZR, nil
+		return backendLibphazr, true
 	default:
-		return 0, fmt.Errorf("unsupported backend %q", name)
+		return 0, false
 	}
 }
 
-func idToName(id C.ec_backend_id_t) string {
+// idToName looks up a backend's name by ID, including those added with
+// RegisterBackend.
+func idToName(id BackendID) string {
+	if name := builtinIDToName(id); name != "" {
+		return name
+	}
+	if name, ok := registeredIDToName(id); ok {
+		return name
+	}
+	return fmt.Sprintf("<unknown backend id %v>", uint8(id))
+}
+
+// builtinIDToName returns the name of one of KnownBackends, or "".
+func builtinIDToName(id BackendID) string {
 	switch id {
-	case C.EC_BACKEND_NULL:
+	case backendNull:
 		return "null"
-	case C.EC_BACKEND_JERASURE_RS_VAND:
+	case backendJerasureRSVand:
 		return "jerasure_rs_vand"
-	case C.EC_BACKEND_JERASURE_RS_CAUCHY:
+	case backendJerasureRSCauchy:
 		return "jerasure_rs_cauchy"
-	case C.EC_BACKEND_FLAT_XOR_HD:
+	case backendFlatXorHD:
 		return "flat_xor_hd"
-	case C.EC_BACKEND_ISA_L_RS_VAND:
+	case backendIsaLRSVand:
 		return "isa_l_rs_vand"
-	case C.EC_BACKEND_SHSS:
+	case backendSHSS:
 		return "shss"
-	case C.EC_BACKEND_LIBERASURECODE_RS_VAND:
+	case backendLiberasurecodeRSVand:
 		return "liberasurecode_rs_vand"
-	case C.EC_BACKEND_ISA_L_RS_CAUCHY:
+	case backendIsaLRSCauchy:
 		return "isa_l_rs_cauchy"
-	case C.EC_BACKEND_LIBPHAZR:
+	case backendLibphazr:
 		return "libphazr"
 	default:
-		return fmt.Sprintf("<unknown backend id %v>", id)
+		return ""
 	}
 }
 
-func errToName(errno C.int) string {
+func errnoName(errno int) string {
 	switch errno {
-	case C.EBACKENDNOTSUPP:
+	case errnoEBACKENDNOTSUPP:
 		return "EBACKENDNOTSUPP"
-	case C.EECMETHODNOTIMPL:
+	case errnoEECMETHODNOTIMPL:
 		return "EECMETHODNOTIMPL"
-	case C.EBACKENDINITERR:
+	case errnoEBACKENDINITERR:
 		return "EBACKENDINITERR"
-	case C.EBACKENDINUSE:
+	case errnoEBACKENDINUSE:
 		return "EBACKENDINUSE"
-	case C.EBACKENDNOTAVAIL:
+	case errnoEBACKENDNOTAVAIL:
 		return "EBACKENDNOTAVAIL"
-	case C.EBADCHKSUM:
+	case errnoEBADCHKSUM:
 		return "EBADCHKSUM"
-	case C.EINVALIDPARAMS:
+	case errnoEINVALIDPARAMS:
 		return "EINVALIDPARAMS"
-	case C.EBADHEADER:
+	case errnoEBADHEADER:
 		return "EBADHEADER"
-	case C.EINSUFFFRAGS:
+	case errnoEINSUFFFRAGS:
 		return "EINSUFFFRAGS"
+	case errnoEPERM:
+		return "EPERM"
+	case errnoENOMEM:
+		return "ENOMEM"
+	case errnoEINVAL:
+		return "EINVAL"
 	default:
 		return fmt.Sprintf("<unknown error code %v>", errno)
 	}
 }
+
+func errToSentinel(errno int) error {
+	switch errno {
+	case errnoEBACKENDNOTSUPP:
+		return ErrBackendNotSupported
+	case errnoEECMETHODNOTIMPL:
+		return ErrMethodNotImplemented
+	case errnoEBACKENDINITERR:
+		return ErrBackendInitError
+	case errnoEBACKENDINUSE:
+		return ErrBackendInUse
+	case errnoEBACKENDNOTAVAIL:
+		return ErrBackendNotAvailable
+	case errnoEBADCHKSUM:
+		return ErrBadChecksum
+	case errnoEINVALIDPARAMS:
+		return ErrInvalidParams
+	case errnoEBADHEADER:
+		return ErrBadHeader
+	case errnoEINSUFFFRAGS:
+		return ErrInsufficientFragments
+	case errnoEPERM:
+		return ErrBackendFailure
+	case errnoENOMEM:
+		return ErrOutOfMemory
+	case errnoEINVAL:
+		return ErrInvalidArgument
+	default:
+		return ErrUnknown
+	}
+}
+
+// newError wraps a negative return code from liberasurecode function op.
+func newError(op string, params Params, rc int) error {
+	return &Error{
+		Op:      op,
+		Backend: params.Name,
+		Errno:   -rc,
+		Params:  params,
+		Err:     errToSentinel(-rc),
+	}
+}
@@ -0,0 +1,267 @@
+package erasurecode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Validate checks params against the constraints of the named backend,
+// returning a *ParamsError naming the offending field. It catches the
+// mistakes liberasurecode would otherwise report only as EINVALIDPARAMS;
+// some combinations (notably for flat_xor_hd) can still be rejected when
+// the backend is created.
+//
+// Backends added with RegisterBackend are checked against the ranges in
+// their BackendFactory.Capabilities, if any were given.
+func (params Params) Validate() error {
+	id, err := nameToID(params.Name)
+	if err != nil {
+		return err
+	}
+	caps, ok := builtinCapabilities[id]
+	if factory, registered := registeredBackend(params.Name); registered {
+		caps, ok = factory.Capabilities, factory.Capabilities.MaxFragments != 0
+	}
+	invalid := func(field, format string, args ...interface{}) error {
+		return &ParamsError{Params: params, Field: field, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if params.K < 1 {
+		return invalid("K", "K=%d, must be at least 1", params.K)
+	}
+	if params.M < 0 {
+		return invalid("M", "M=%d, must not be negative", params.M)
+	}
+	switch params.ChecksumType {
+	case 0, ChecksumNone, ChecksumCRC32, ChecksumMD5:
+	default:
+		return invalid("ChecksumType", "unknown checksum type %d", uint8(params.ChecksumType))
+	}
+	switch params.CRCVariant {
+	case CRCDefault, CRCStandard, CRCLegacy:
+	default:
+		return invalid("CRCVariant", "unknown CRC variant %d", uint8(params.CRCVariant))
+	}
+	if !ok {
+		return nil
+	}
+
+	if !caps.K.Contains(params.K) {
+		return invalid("K", "K=%d, must be in the range %v", params.K, caps.K)
+	}
+	if !caps.M.Contains(params.M) {
+		return invalid("M", "M=%d, must be in the range %v", params.M, caps.M)
+	}
+	if n := params.K + params.M; n > caps.MaxFragments {
+		return invalid("M", "K+M=%d, must be at most %d", n, caps.MaxFragments)
+	}
+	if len(caps.W) > 0 {
+		w := params.W
+		if w == 0 {
+			w = caps.DefaultW
+		}
+		valid := false
+		for _, allowed := range caps.W {
+			valid = valid || w == allowed
+		}
+		if !valid {
+			return invalid("W", "W=%d, must be one of %v", params.W, caps.W)
+		}
+		// Reed-Solomon codes need a distinct field element per fragment.
+		if n := params.K + params.M; w < 31 && n > 1<<uint(w) {
+			return invalid("W", "K+M=%d, must be at most 2^W=%d", n, 1<<uint(w))
+		}
+	}
+	if caps.NeedsHD && !caps.HD.Contains(params.HD) {
+		return invalid("HD", "HD=%d, must be in the range %v", params.HD, caps.HD)
+	}
+	return nil
+}
+
+// String gives params in the compact form ParsePolicy reads, such as
+// "isa_l_rs_vand:k=10,m=4". W, HD, ChecksumType and CRCVariant are only
+// included if set.
+func (params Params) String() string {
+	s := fmt.Sprintf("%s:k=%d,m=%d", params.Name, params.K, params.M)
+	if params.W != 0 {
+		s += fmt.Sprintf(",w=%d", params.W)
+	}
+	if params.HD != 0 {
+		s += fmt.Sprintf(",hd=%d", params.HD)
+	}
+	if params.ChecksumType != 0 {
+		s += fmt.Sprintf(",checksum=%v", params.ChecksumType)
+	}
+	if params.CRCVariant != CRCDefault {
+		s += fmt.Sprintf(",legacy_crc=%t", params.CRCVariant == CRCLegacy)
+	}
+	return s
+}
+
+// ParsePolicy reads params in the form written by Params.String: a backend
+// name, then a colon and comma-separated key=value pairs for k, m, w, hd,
+// checksum (one of none, crc32 or md5) and legacy_crc (a boolean, choosing
+// CRCLegacy or CRCStandard). Swift's flat_xor_hd_3 and flat_xor_hd_4
+// names are accepted as shorthand for flat_xor_hd with hd=3 or hd=4. The
+// result is not validated; InitBackend does that.
+func ParsePolicy(policy string) (Params, error) {
+	invalid := func(format string, args ...interface{}) (Params, error) {
+		return Params{}, fmt.Errorf("invalid policy %q: %s: %w",
+			policy, fmt.Sprintf(format, args...), ErrInvalidParams)
+	}
+	name, opts := policy, ""
+	if i := strings.IndexByte(policy, ':'); i >= 0 {
+		name, opts = policy[:i], policy[i+1:]
+	}
+	if name == "" {
+		return invalid("missing backend name")
+	}
+	var params Params
+	params.Name, params.HD = ecTypeToName(name)
+	if opts == "" {
+		return params, nil
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		eq := strings.IndexByte(opt, '=')
+		if eq < 0 {
+			return invalid("expected key=value, got %q", opt)
+		}
+		key, val := strings.TrimSpace(opt[:eq]), strings.TrimSpace(opt[eq+1:])
+		var field *int
+		switch strings.ToLower(key) {
+		case "k":
+			field = &params.K
+		case "m":
+			field = &params.M
+		case "w":
+			field = &params.W
+		case "hd":
+			field = &params.HD
+		case "checksum":
+			ct, err := parseChecksumType(val)
+			if err != nil {
+				return invalid("%v", err)
+			}
+			params.ChecksumType = ct
+			continue
+		case "legacy_crc":
+			legacy, err := strconv.ParseBool(val)
+			if err != nil {
+				return invalid("legacy_crc must be a boolean, got %q", val)
+			}
+			params.CRCVariant = crcVariant(legacy)
+			continue
+		default:
+			return invalid("unknown key %q", key)
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return invalid("%s must be an integer, got %q", key, val)
+		}
+		*field = n
+	}
+	return params, nil
+}
+
+// ecTypeToName maps Swift's ec_type names, which use separate backends for
+// each flat_xor_hd Hamming distance, to ours.
+func ecTypeToName(ecType string) (name string, hd int) {
+	switch ecType {
+	case "flat_xor_hd_3":
+		return "flat_xor_hd", 3
+	case "flat_xor_hd_4":
+		return "flat_xor_hd", 4
+	default:
+		return ecType, 0
+	}
+}
+
+// crcVariant returns the CRCVariant legacy_crc=legacy asks for.
+func crcVariant(legacy bool) CRCVariant {
+	if legacy {
+		return CRCLegacy
+	}
+	return CRCStandard
+}
+
+func parseChecksumType(s string) (ChecksumType, error) {
+	for _, ct := range []ChecksumType{ChecksumNone, ChecksumCRC32, ChecksumMD5} {
+		if strings.EqualFold(s, ct.String()) {
+			return ct, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown checksum type %q", s)
+}
+
+// MarshalText encodes params in the compact form written by String.
+func (params Params) MarshalText() ([]byte, error) {
+	return []byte(params.String()), nil
+}
+
+// UnmarshalText decodes params in the compact form read by ParsePolicy.
+func (params *Params) UnmarshalText(text []byte) error {
+	parsed, err := ParsePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*params = parsed
+	return nil
+}
+
+// jsonParams is the JSON object form of Params.
+type jsonParams struct {
+	Name         string `json:"name"`
+	K            int    `json:"k"`
+	M            int    `json:"m"`
+	W            int    `json:"w,omitempty"`
+	HD           int    `json:"hd,omitempty"`
+	ChecksumType string `json:"checksum,omitempty"`
+	LegacyCRC    *bool  `json:"legacy_crc,omitempty"`
+}
+
+// MarshalJSON encodes params as an object such as
+// {"name":"isa_l_rs_vand","k":10,"m":4}.
+func (params Params) MarshalJSON() ([]byte, error) {
+	jp := jsonParams{Name: params.Name, K: params.K, M: params.M, W: params.W, HD: params.HD}
+	if params.CRCVariant != CRCDefault {
+		legacy := params.CRCVariant == CRCLegacy
+		jp.LegacyCRC = &legacy
+	}
+	if params.ChecksumType != 0 {
+		jp.ChecksumType = params.ChecksumType.String()
+	}
+	return json.Marshal(jp)
+}
+
+// UnmarshalJSON decodes params from either the object form written by
+// MarshalJSON or a string in the compact form read by ParsePolicy.
+func (params *Params) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return params.UnmarshalText([]byte(s))
+	}
+	var jp jsonParams
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	parsed := Params{K: jp.K, M: jp.M, W: jp.W, HD: jp.HD}
+	if jp.LegacyCRC != nil {
+		parsed.CRCVariant = crcVariant(*jp.LegacyCRC)
+	}
+	var hd int
+	parsed.Name, hd = ecTypeToName(jp.Name)
+	if parsed.HD == 0 {
+		parsed.HD = hd
+	}
+	if jp.ChecksumType != "" {
+		ct, err := parseChecksumType(jp.ChecksumType)
+		if err != nil {
+			return fmt.Errorf("%v: %w", err, ErrInvalidParams)
+		}
+		parsed.ChecksumType = ct
+	}
+	*params = parsed
+	return nil
+}
@@ -0,0 +1,213 @@
+package erasurecode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		policy string
+		want   Params
+	}{
+		{"isa_l_rs_vand:k=10,m=4", Params{Name: "isa_l_rs_vand", K: 10, M: 4}},
+		{"isa_l_rs_vand:k=10,m=4,w=8", Params{Name: "isa_l_rs_vand", K: 10, M: 4, W: 8}},
+		{"flat_xor_hd:k=3,m=3,hd=3", Params{Name: "flat_xor_hd", K: 3, M: 3, HD: 3}},
+		{"flat_xor_hd_4:k=10,m=6", Params{Name: "flat_xor_hd", K: 10, M: 6, HD: 4}},
+		{"liberasurecode_rs_vand:k=2,m=1,checksum=none",
+			Params{Name: "liberasurecode_rs_vand", K: 2, M: 1, ChecksumType: ChecksumNone}},
+		{"null:K=4, M=0", Params{Name: "null", K: 4}},
+		{"null", Params{Name: "null"}},
+		{"isa_l_rs_vand:k=10,m=4,legacy_crc=true", Params{Name: "isa_l_rs_vand", K: 10, M: 4, CRCVariant: CRCLegacy}},
+		{"isa_l_rs_vand:k=10,m=4,legacy_crc=false", Params{Name: "isa_l_rs_vand", K: 10, M: 4, CRCVariant: CRCStandard}},
+	}
+	for _, args := range cases {
+		params, err := ParsePolicy(args.policy)
+		if err != nil {
+			t.Errorf("ParsePolicy(%q) produced error %v", args.policy, err)
+			continue
+		}
+		if params != args.want {
+			t.Errorf("ParsePolicy(%q) produced %#v, want %#v", args.policy, params, args.want)
+		}
+		if roundTrip, err := ParsePolicy(params.String()); err != nil || roundTrip != params {
+			t.Errorf("%q did not round-trip: got %#v (%v)", params.String(), roundTrip, err)
+		}
+	}
+
+	for _, policy := range []string{
+		"",
+		":k=10,m=4",
+		"isa_l_rs_vand:k=10,m",
+		"isa_l_rs_vand:k=ten,m=4",
+		"isa_l_rs_vand:k=10,m=4,x=1",
+		"isa_l_rs_vand:k=10,m=4,checksum=sha1",
+		"isa_l_rs_vand:k=10,m=4,legacy_crc=maybe",
+	} {
+		if _, err := ParsePolicy(policy); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("ParsePolicy(%q) produced %v, want ErrInvalidParams", policy, err)
+		}
+	}
+
+	if s := (Params{Name: "isa_l_rs_vand", K: 10, M: 4, W: 8}).String(); s != "isa_l_rs_vand:k=10,m=4,w=8" {
+		t.Errorf("Unexpected string %q", s)
+	}
+}
+
+func TestParamsMarshaling(t *testing.T) {
+	params := Params{Name: "isa_l_rs_vand", K: 10, M: 4, ChecksumType: ChecksumCRC32}
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"name":"isa_l_rs_vand","k":10,"m":4,"checksum":"crc32"}`; string(data) != want {
+		t.Errorf("Expected %s, got %s", want, data)
+	}
+	var decoded Params
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != params {
+		t.Errorf("Expected %#v, got %#v (%v)", params, decoded, err)
+	}
+	decoded = Params{}
+	if err := json.Unmarshal([]byte(`"isa_l_rs_vand:k=10,m=4,checksum=crc32"`), &decoded); err != nil || decoded != params {
+		t.Errorf("Expected %#v, got %#v (%v)", params, decoded, err)
+	}
+
+	text, err := params.MarshalText()
+	if err != nil || string(text) != params.String() {
+		t.Errorf("Expected %q, got %q (%v)", params.String(), text, err)
+	}
+	decoded = Params{}
+	if err := decoded.UnmarshalText(text); err != nil || decoded != params {
+		t.Errorf("Expected %#v, got %#v (%v)", params, decoded, err)
+	}
+
+	// An explicit standard CRC survives the trip, distinct from the default
+	standard := Params{Name: "isa_l_rs_vand", K: 10, M: 4, CRCVariant: CRCStandard}
+	data, err = json.Marshal(standard)
+	if err != nil || string(data) != `{"name":"isa_l_rs_vand","k":10,"m":4,"legacy_crc":false}` {
+		t.Errorf("Unexpected encoding %s (%v)", data, err)
+	}
+	decoded = Params{}
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != standard {
+		t.Errorf("Expected %#v, got %#v (%v)", standard, decoded, err)
+	}
+
+	// As a map key, Params use the text form
+	data, err = json.Marshal(map[Params]int{params: 1})
+	if err != nil || string(data) != `{"isa_l_rs_vand:k=10,m=4,checksum=crc32":1}` {
+		t.Errorf("Unexpected map encoding %s (%v)", data, err)
+	}
+}
+
+func TestBackendMarshaling(t *testing.T) {
+	params := Params{Name: "isa_l_rs_vand", K: 10, M: 4, ChecksumType: ChecksumCRC32}
+	backend := initGoBackend(t, params)
+	defer backend.Close()
+	if got := fmt.Sprint(backend); got != params.String() {
+		t.Errorf("Expected %q, got %q", params.String(), got)
+	}
+	data, err := json.Marshal(backend)
+	if want, _ := json.Marshal(params); err != nil || string(data) != string(want) {
+		t.Errorf("Expected %s, got %s (%v)", want, data, err)
+	}
+	// A live Backend's Params can't be swapped out from under it.
+	if err := json.Unmarshal([]byte(`"isa_l_rs_vand:k=4,m=2"`), &backend); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams, got %v", err)
+	}
+	if err := backend.UnmarshalText([]byte("isa_l_rs_vand:k=4,m=2")); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams, got %v", err)
+	}
+	if backend.Params != params {
+		t.Errorf("Expected Params to be left alone, got %v", backend.Params)
+	}
+}
+
+const testSwiftConf = `
+[swift-hash]
+swift_hash_path_suffix = changeme
+
+[storage-policy:0]
+name = gold
+default = yes
+
+# An EC policy
+[storage-policy:2]
+name = ec-10-4
+aliases = ec, ec104
+policy_type = erasure_coding
+ec_type = isa_l_rs_vand
+ec_num_data_fragments = 10
+ec_num_parity_fragments = 4
+ec_object_segment_size = 4194304
+
+[storage-policy:1]
+name: xor
+policy_type: erasure_coding
+ec_type: flat_xor_hd_3
+ec_num_data_fragments: 10
+ec_num_parity_fragments: 5
+ec_duplication_factor: 2
+deprecated = true
+`
+
+func TestParseSwiftPolicies(t *testing.T) {
+	policies, err := ParseSwiftPolicies(strings.NewReader(testSwiftConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("Expected 2 EC policies, got %+v", policies)
+	}
+	xor, ec := policies[0], policies[1]
+	if xor.Index != 1 || xor.Name != "xor" || !xor.Deprecated || xor.Default ||
+		xor.Params != (Params{Name: "flat_xor_hd", K: 10, M: 5, HD: 3}) ||
+		xor.SegmentSize != DefaultSegmentSize || xor.DuplicationFactor != 2 {
+		t.Errorf("Unexpected policy %+v", xor)
+	}
+	if ec.Index != 2 || ec.Name != "ec-10-4" || ec.Deprecated ||
+		ec.Params != (Params{Name: "isa_l_rs_vand", K: 10, M: 4}) ||
+		ec.SegmentSize != 4194304 || ec.DuplicationFactor != 1 ||
+		len(ec.Aliases) != 2 || ec.Aliases[1] != "ec104" {
+		t.Errorf("Unexpected policy %+v", ec)
+	}
+
+	for _, name := range []string{"2", "ec-10-4", "EC104"} {
+		if policy, ok := FindPolicy(policies, name); !ok || policy.Index != 2 {
+			t.Errorf("FindPolicy(%q) produced %+v, %v", name, policy, ok)
+		}
+	}
+	if _, ok := FindPolicy(policies, "gold"); ok {
+		t.Errorf("Expected replication policy to be skipped")
+	}
+
+	backend, err := InitBackend(ec.Params)
+	if !BackendIsAvailable(ec.Params.Name) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("Error creating backend for %v: %v", ec.Params, err)
+	}
+	backend.Close()
+}
+
+func TestParseSwiftPoliciesErrors(t *testing.T) {
+	for _, conf := range []string{
+		"key = value before any section",
+		"[storage-policy:1]\npolicy_type = erasure_coding\nec_num_data_fragments = 10\nec_num_parity_fragments = 4",
+		"[storage-policy:1]\npolicy_type = erasure_coding\nec_type = isa_l_rs_vand\nec_num_data_fragments = 10",
+		"[storage-policy:x]\npolicy_type = erasure_coding\nec_type = isa_l_rs_vand\nec_num_data_fragments = 10\nec_num_parity_fragments = 4",
+		"[storage-policy:1]\npolicy_type = erasure_coding\nec_type = isa_l_rs_vand\nec_num_data_fragments = 10\nec_num_parity_fragments = 4\nec_object_segment_size = 0",
+	} {
+		if policies, err := ParseSwiftPolicies(strings.NewReader(conf)); err == nil {
+			t.Errorf("Expected error parsing %q, got %+v", conf, policies)
+		}
+	}
+
+	if _, err := LoadSwiftPolicies(os.TempDir() + "/erasurecode-missing-swift.conf"); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
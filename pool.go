@@ -0,0 +1,316 @@
+package erasurecode
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A BackendPool shares backends between callers using the same Params, so
+// the cost of creating an instance (setting up jerasure or ISA-L matrices,
+// say) is paid once rather than on every request. Instances are created on
+// first use, and closed once no Backend from Get has used them for
+// IdleTimeout. The zero BackendPool is ready to use.
+type BackendPool struct {
+	// IdleTimeout is how long an instance is kept once every Backend
+	// using it has been closed. If zero, it is closed straight away.
+	IdleTimeout time.Duration
+	// Leaked, if set, is called with the Params of each Backend from Get
+	// that is garbage collected without having been closed. It is called
+	// from the finalizer goroutine, so should not block.
+	Leaked func(params Params)
+
+	// initBackend creates instances; nil means InitBackend. Tests
+	// replace it to control how long creation takes.
+	initBackend func(params Params) (Backend, error)
+
+	mu      sync.Mutex
+	entries map[Params]*poolEntry
+}
+
+// DefaultBackendPool is a process-wide pool, keeping idle instances for a
+// minute.
+var DefaultBackendPool = &BackendPool{IdleTimeout: time.Minute}
+
+// PoolStats describes the use a BackendPool has made of one Params.
+type PoolStats struct {
+	Params  Params
+	Alive   bool // whether an instance currently exists
+	InUse   int  // Backends from Get that have not been closed
+	Created int  // instances created, including after eviction
+	Evicted int  // instances closed after being idle, or by Close
+	Leaked  int  // Backends garbage collected without being closed
+}
+
+type poolEntry struct {
+	stats PoolStats
+	inst  *pooledInstance // nil if no instance is alive
+	// creating is closed once an instance being created without the
+	// pool's lock held is ready, or has failed; nil if none is.
+	creating chan struct{}
+}
+
+// pooledInstance is one backend shared by every Backend from Get for its
+// Params. Once evicted it is no longer any entry's inst, but Backends
+// that still refer to it keep it until they are closed.
+type pooledInstance struct {
+	backend Backend
+	handles int
+	idle    *time.Timer
+}
+
+// Get returns a Backend for params, sharing an instance with other callers
+// using the same Params. The Backend must be closed when done with, which
+// returns the instance to the pool rather than destroying it. As with
+// InitBackend, copies of the Backend share it, and it is safe to use from
+// several goroutines.
+//
+// Instances are created without holding up calls for other Params; calls
+// for the same Params wait for the one creating its instance.
+func (pool *BackendPool) Get(params Params) (Backend, error) {
+	entry, inst, err := pool.acquire(params)
+	if err != nil {
+		return Backend{}, err
+	}
+	handle := newBackend(params, pooledEngine{pool, entry, inst})
+	runtime.SetFinalizer(handle.shared, func(state *backendState) {
+		if state.impl == nil {
+			return
+		}
+		pool.mu.Lock()
+		entry.stats.Leaked++
+		pool.mu.Unlock()
+		if pool.Leaked != nil {
+			pool.Leaked(params)
+		}
+		state.impl.close()
+	})
+	return handle, nil
+}
+
+// acquire finds or creates the instance for params and takes a handle on
+// it.
+func (pool *BackendPool) acquire(params Params) (*poolEntry, *pooledInstance, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for {
+		if pool.entries == nil {
+			pool.entries = make(map[Params]*poolEntry)
+		}
+		entry := pool.entries[params]
+		if entry == nil {
+			entry = &poolEntry{stats: PoolStats{Params: params}}
+			pool.entries[params] = entry
+		}
+		if inst := entry.inst; inst != nil {
+			if inst.idle != nil {
+				inst.idle.Stop()
+				inst.idle = nil
+			}
+			inst.handles++
+			return entry, inst, nil
+		}
+		if creating := entry.creating; creating != nil {
+			// Wait, then look again: the instance may have been
+			// evicted already, or failed to be created.
+			pool.mu.Unlock()
+			<-creating
+			pool.mu.Lock()
+			continue
+		}
+
+		creating := make(chan struct{})
+		entry.creating = creating
+		pool.mu.Unlock()
+		initBackend := pool.initBackend
+		if initBackend == nil {
+			initBackend = InitBackend
+		}
+		backend, err := initBackend(params)
+		pool.mu.Lock()
+		entry.creating = nil
+		close(creating)
+		if err != nil {
+			if entry.stats.Created == 0 && pool.entries[params] == entry {
+				delete(pool.entries, params)
+			}
+			return nil, nil, err
+		}
+		entry.inst = &pooledInstance{backend: backend}
+		entry.stats.Created++
+	}
+}
+
+// release notes that a Backend using inst has been closed, scheduling inst
+// to be closed if nothing else is using it.
+func (pool *BackendPool) release(entry *poolEntry, inst *pooledInstance) {
+	pool.mu.Lock()
+	inst.handles--
+	if inst.handles > 0 || entry.inst != inst {
+		pool.mu.Unlock()
+		return
+	}
+	if pool.IdleTimeout > 0 {
+		inst.idle = time.AfterFunc(pool.IdleTimeout, func() {
+			pool.evict(entry, inst)
+		})
+		pool.mu.Unlock()
+		return
+	}
+	pool.mu.Unlock()
+	pool.evict(entry, inst)
+}
+
+// evict closes inst, unless it has been taken up again since going idle.
+func (pool *BackendPool) evict(entry *poolEntry, inst *pooledInstance) {
+	pool.mu.Lock()
+	if entry.inst != inst || inst.handles > 0 {
+		pool.mu.Unlock()
+		return
+	}
+	entry.inst = nil
+	entry.stats.Evicted++
+	pool.mu.Unlock()
+	inst.backend.Close()
+}
+
+// Stats reports on each Params the pool has been asked for, ordered by
+// their String form.
+func (pool *BackendPool) Stats() []PoolStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	stats := make([]PoolStats, 0, len(pool.entries))
+	for _, entry := range pool.entries {
+		if entry.stats.Created == 0 {
+			continue // its first instance is still being created
+		}
+		s := entry.stats
+		if entry.inst != nil {
+			s.Alive = true
+			s.InUse = entry.inst.handles
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Params.String() < stats[j].Params.String()
+	})
+	return stats
+}
+
+// Close closes every instance in the pool, waiting for any calls in
+// progress. Backends from Get that are still open fail from then on with
+// ErrBackendClosed (but should still be closed); later calls to Get
+// create new instances.
+func (pool *BackendPool) Close() error {
+	pool.mu.Lock()
+	var insts []*pooledInstance
+	for _, entry := range pool.entries {
+		if inst := entry.inst; inst != nil {
+			if inst.idle != nil {
+				inst.idle.Stop()
+			}
+			entry.inst = nil
+			entry.stats.Evicted++
+			insts = append(insts, inst)
+		}
+	}
+	pool.mu.Unlock()
+
+	var firstErr error
+	for _, inst := range insts {
+		if err := inst.backend.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pooledEngine is the engine behind each Backend from BackendPool.Get. It
+// passes calls on to the shared instance, and closing it just releases
+// that instance back to the pool.
+type pooledEngine struct {
+	pool  *BackendPool
+	entry *poolEntry
+	inst  *pooledInstance
+}
+
+func (e pooledEngine) encode(data []byte) ([][]byte, error) {
+	return e.inst.backend.Encode(data)
+}
+
+func (e pooledEngine) decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	return e.inst.backend.decode(frags, forceMetadataChecks)
+}
+
+func (e pooledEngine) reconstruct(frags [][]byte, fragIndex int) ([]byte, error) {
+	return e.inst.backend.Reconstruct(frags, fragIndex)
+}
+
+func (e pooledEngine) reconstructMany(ctx context.Context, frags [][]byte, indexes []int) (map[int][]byte, error) {
+	return e.inst.backend.ReconstructMany(frags, indexes)
+}
+
+func (e pooledEngine) encodeContext(ctx context.Context, data []byte) ([][]byte, error) {
+	return e.inst.backend.EncodeContext(ctx, data)
+}
+
+func (e pooledEngine) decodeContext(ctx context.Context, frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	impl, release := e.inst.backend.acquire()
+	defer release()
+	if c, ok := impl.(contextEngine); ok {
+		return c.decodeContext(ctx, frags, forceMetadataChecks)
+	}
+	return decodeColumns(ctx, impl, e.inst.backend.Params, frags, forceMetadataChecks)
+}
+
+func (e pooledEngine) reconstructContext(ctx context.Context, frags [][]byte, fragIndex int) ([]byte, error) {
+	return e.inst.backend.ReconstructContext(ctx, frags, fragIndex)
+}
+
+func (e pooledEngine) encodeTo(data []byte, dst [][]byte) error {
+	return e.inst.backend.EncodeTo(data, dst)
+}
+
+func (e pooledEngine) decodeTo(frags [][]byte, forceMetadataChecks bool, dst []byte) ([]byte, error) {
+	impl, release := e.inst.backend.acquire()
+	defer release()
+	if b, ok := impl.(bufferEngine); ok {
+		return b.decodeTo(frags, forceMetadataChecks, dst)
+	}
+	data, err := impl.decode(frags, forceMetadataChecks)
+	if err != nil {
+		return nil, err
+	}
+	return append(resize(dst, 0), data...), nil
+}
+
+func (e pooledEngine) reconstructTo(frags [][]byte, fragIndex int, dst []byte) ([]byte, error) {
+	return e.inst.backend.ReconstructTo(frags, fragIndex, dst)
+}
+
+func (e pooledEngine) isInvalidFragment(frag []byte) bool {
+	return e.inst.backend.IsInvalidFragment(frag)
+}
+
+func (e pooledEngine) fragmentsNeeded(want, exclude []int) ([]int, error) {
+	return e.inst.backend.FragmentsNeeded(want, exclude)
+}
+
+func (e pooledEngine) alignedDataSize(dataLen int) (int, error) {
+	return e.inst.backend.AlignedDataSize(dataLen)
+}
+
+func (e pooledEngine) minimumEncodeSize() (int, error) {
+	return e.inst.backend.MinimumEncodeSize()
+}
+
+func (e pooledEngine) fragmentSize(dataLen int) (int, error) {
+	return e.inst.backend.FragmentSize(dataLen)
+}
+
+func (e pooledEngine) close() error {
+	e.pool.release(e.entry, e.inst)
+	return nil
+}
@@ -0,0 +1,229 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var poolParams = Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+
+func poolStats(t *testing.T, pool *BackendPool, params Params) PoolStats {
+	for _, s := range pool.Stats() {
+		if s.Params == params {
+			return s
+		}
+	}
+	t.Fatalf("No stats for %v", params)
+	return PoolStats{}
+}
+
+func TestBackendPoolSharing(t *testing.T) {
+	pool := &BackendPool{}
+	a, err := pool.Get(poolParams)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v", err)
+	}
+	b, err := pool.Get(poolParams)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v", err)
+	}
+	if a.current().(pooledEngine).inst != b.current().(pooledEngine).inst {
+		t.Errorf("Expected backends for the same Params to share an instance")
+	}
+	if s := poolStats(t, pool, poolParams); !s.Alive || s.InUse != 2 || s.Created != 1 {
+		t.Errorf("Unexpected stats with two backends open: %+v", s)
+	}
+
+	frags, err := a.Encode(testPatterns[2])
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	if data, err := b.Decode(frags[poolParams.M:]); err != nil || !bytes.Equal(data, testPatterns[2]) {
+		t.Errorf("Pattern did not round-trip between pooled backends (%v)", err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Errorf("Error closing backend: %v", err)
+	}
+	if _, err := a.Encode(testPatterns[2]); !errors.Is(err, ErrBackendClosed) {
+		t.Errorf("Expected ErrBackendClosed after Close, got %v", err)
+	}
+	if _, err := b.Encode(testPatterns[2]); err != nil {
+		t.Errorf("Error encoding with the other backend: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("Error closing backend: %v", err)
+	}
+	// With no IdleTimeout, the instance goes as soon as it is unused.
+	if s := poolStats(t, pool, poolParams); s.Alive || s.InUse != 0 || s.Evicted != 1 {
+		t.Errorf("Unexpected stats after closing both backends: %+v", s)
+	}
+
+	c, err := pool.Get(poolParams)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v", err)
+	}
+	defer c.Close()
+	if s := poolStats(t, pool, poolParams); !s.Alive || s.Created != 2 {
+		t.Errorf("Expected a new instance after eviction: %+v", s)
+	}
+	other := Params{Name: "liberasurecode_rs_vand", K: 3, M: 2}
+	d, err := pool.Get(other)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v", err)
+	}
+	defer d.Close()
+	if stats := pool.Stats(); len(stats) != 2 || stats[0].Params != other {
+		t.Errorf("Expected stats for both Params, in order; got %+v", stats)
+	}
+
+	if _, err := pool.Get(Params{Name: "liberasurecode_rs_vand", K: 0, M: 2}); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams, got %v", err)
+	}
+	if len(pool.Stats()) != 2 {
+		t.Errorf("Expected no stats for Params that failed")
+	}
+}
+
+func TestBackendPoolConcurrentCreation(t *testing.T) {
+	started := make(chan struct{}, 2)
+	gate := make(chan struct{})
+	pool := &BackendPool{initBackend: func(params Params) (Backend, error) {
+		if params == poolParams {
+			started <- struct{}{}
+			<-gate
+		}
+		return InitBackend(params)
+	}}
+	type result struct {
+		backend Backend
+		err     error
+	}
+	results := make(chan result, 2)
+	get := func() {
+		backend, err := pool.Get(poolParams)
+		results <- result{backend, err}
+	}
+	go get()
+	<-started
+	go get()
+
+	// Creating one instance doesn't hold up Get for other Params.
+	other := Params{Name: "liberasurecode_rs_vand", K: 3, M: 2}
+	done := make(chan error, 1)
+	go func() {
+		backend, err := pool.Get(other)
+		if err == nil {
+			err = backend.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Error getting backend: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		close(gate)
+		t.Fatalf("Get for other Params blocked while an instance was created")
+	}
+	if stats := pool.Stats(); len(stats) != 1 || stats[0].Params != other {
+		t.Errorf("Expected no stats for the instance being created, got %+v", stats)
+	}
+
+	close(gate)
+	a, b := <-results, <-results
+	if a.err != nil || b.err != nil {
+		t.Fatalf("Error getting backends: %v, %v", a.err, b.err)
+	}
+	defer a.backend.Close()
+	defer b.backend.Close()
+	if len(started) != 0 {
+		t.Errorf("Expected one instance to be created, not one per caller")
+	}
+	if a.backend.current().(pooledEngine).inst != b.backend.current().(pooledEngine).inst {
+		t.Errorf("Expected callers waiting on creation to share the instance")
+	}
+	if s := poolStats(t, pool, poolParams); !s.Alive || s.InUse != 2 || s.Created != 1 {
+		t.Errorf("Unexpected stats after concurrent Gets: %+v", s)
+	}
+}
+
+func TestBackendPoolIdleTimeout(t *testing.T) {
+	pool := &BackendPool{IdleTimeout: 20 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		backend, err := pool.Get(poolParams)
+		if err != nil {
+			t.Fatalf("Error getting backend: %v", err)
+		}
+		if err := backend.Close(); err != nil {
+			t.Errorf("Error closing backend: %v", err)
+		}
+	}
+	if s := poolStats(t, pool, poolParams); !s.Alive || s.Created != 1 {
+		t.Errorf("Expected the instance to be reused: %+v", s)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for poolStats(t, pool, poolParams).Alive {
+		if time.Now().After(deadline) {
+			t.Fatalf("Idle instance was never evicted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s := poolStats(t, pool, poolParams); s.Evicted != 1 {
+		t.Errorf("Unexpected stats after eviction: %+v", s)
+	}
+}
+
+func TestBackendPoolClose(t *testing.T) {
+	pool := &BackendPool{IdleTimeout: time.Hour}
+	backend, err := pool.Get(poolParams)
+	if err != nil {
+		t.Fatalf("Error getting backend: %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Errorf("Error closing pool: %v", err)
+	}
+	if _, err := backend.Encode(testPatterns[2]); !errors.Is(err, ErrBackendClosed) {
+		t.Errorf("Expected ErrBackendClosed after closing the pool, got %v", err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Errorf("Error closing backend: %v", err)
+	}
+	if s := poolStats(t, pool, poolParams); s.Alive || s.InUse != 0 || s.Evicted != 1 {
+		t.Errorf("Unexpected stats after closing pool: %+v", s)
+	}
+}
+
+func TestBackendPoolLeak(t *testing.T) {
+	leaked := make(chan Params, 1)
+	pool := &BackendPool{Leaked: func(params Params) { leaked <- params }}
+	func() {
+		backend, err := pool.Get(poolParams)
+		if err != nil {
+			t.Fatalf("Error getting backend: %v", err)
+		}
+		backend.Encode(testPatterns[2])
+	}()
+
+	for i := 0; ; i++ {
+		runtime.GC()
+		select {
+		case params := <-leaked:
+			if params != poolParams {
+				t.Errorf("Expected leak of %v, got %v", poolParams, params)
+			}
+			if s := poolStats(t, pool, poolParams); s.Leaked != 1 || s.Alive {
+				t.Errorf("Unexpected stats after leak: %+v", s)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if i == 100 {
+			t.Fatalf("Leaked backend was never reported")
+		}
+	}
+}
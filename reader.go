@@ -0,0 +1,205 @@
+package erasurecode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ECReader reassembles an object from fragment archives such as those
+// written by an ECWriter: each archive holds one fragment of every segment,
+// in order. For each segment, a fragment is read from the first K healthy
+// archives and decoded.
+//
+// An archive fails if it can't be read, is shorter than the others, or
+// gives a fragment that is corrupt or not one Backend could have produced.
+// Fragments are then read from the next healthy archive instead, skipping
+// over those for the segments already decoded, so reading succeeds so long
+// as K archives remain healthy. Failures shows which have failed.
+type ECReader struct {
+	Backend  Coder
+	Archives []io.Reader
+
+	sources []*archiveSource
+	segment int64  // the number of the next segment to decode
+	data    []byte // decoded but not yet read
+	buf     []byte // storage for data
+	frags   [][]byte
+	err     error
+}
+
+// archiveSource tracks how far through an archive an ECReader has read.
+type archiveSource struct {
+	r       io.Reader
+	segment int64  // the number of the segment the next fragment is for
+	buf     []byte // storage for the last fragment read
+	err     error  // why the archive failed, if it has
+}
+
+// NewReader returns a reader for the object stored in archives, which
+// would usually be the K+M archives written by GetFileWriter, though any K
+// of them will do. See ECReader.
+func (backend *Backend) NewReader(archives []io.Reader) io.Reader {
+	return &ECReader{Backend: backend, Archives: archives}
+}
+
+func (r *ECReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.decodeSegment()
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Failures describes the archives that have failed so far, in order.
+func (r *ECReader) Failures() []ArchiveError {
+	var failures []ArchiveError
+	for i, src := range r.sources {
+		if src.err != nil {
+			failures = append(failures, ArchiveError{Index: i, Err: src.err})
+		}
+	}
+	return failures
+}
+
+// decodeSegment reads and decodes the next segment into r.data, returning
+// io.EOF once every archive has ended.
+func (r *ECReader) decodeSegment() error {
+	if r.sources == nil {
+		r.sources = make([]*archiveSource, len(r.Archives))
+		for i, archive := range r.Archives {
+			r.sources[i] = &archiveSource{r: archive}
+		}
+	}
+	k := r.Backend.Parameters().K
+	r.frags = r.frags[:0]
+	seen := make(map[int]bool, k)
+	var ended []*archiveSource
+	for _, src := range r.sources {
+		if len(r.frags) == k {
+			break
+		}
+		if src.err != nil {
+			continue
+		}
+		frag, err := r.next(src)
+		if err == io.EOF {
+			ended = append(ended, src)
+			continue
+		}
+		if err != nil {
+			src.err = err
+			continue
+		}
+		// Two archives with the same fragment are no use together.
+		if index := GetFragmentInfo(frag).Index; !seen[index] {
+			seen[index] = true
+			r.frags = append(r.frags, frag)
+		}
+	}
+
+	if len(r.frags) == 0 && len(ended) > 0 {
+		return io.EOF
+	}
+	// Any that ended early were truncated.
+	for _, src := range ended {
+		src.err = io.ErrUnexpectedEOF
+	}
+	if len(r.frags) < k {
+		return insufficientFragments(r.segment, len(r.frags), k, r.Failures())
+	}
+
+	var err error
+	if decoder, ok := r.Backend.(bufferDecoder); ok {
+		r.buf, err = decoder.DecodeTo(r.frags, r.buf)
+	} else {
+		r.buf, err = r.Backend.Decode(r.frags)
+	}
+	if err != nil {
+		return fmt.Errorf("segment %d: %w", r.segment, err)
+	}
+	r.data = r.buf
+	r.segment++
+	return nil
+}
+
+// next returns src's fragment of the current segment, first skipping any
+// for earlier segments that weren't needed. It returns io.EOF if src ends
+// cleanly where that fragment should start.
+func (r *ECReader) next(src *archiveSource) ([]byte, error) {
+	for ; src.segment < r.segment; src.segment++ {
+		if err := skipFragment(src.r); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	frag, err := readFragment(src.r, src.buf)
+	if len(frag) > 0 {
+		src.buf = frag
+	}
+	if err == io.EOF {
+		return nil, err
+	}
+	if err == nil {
+		src.segment++
+		err = checkFragment(r.Backend, frag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %w", r.segment, err)
+	}
+	return frag, nil
+}
+
+// checkFragment checks that frag is intact and that coder could have
+// produced it. A payload checksum that can't be verified (MD5, say) is
+// taken on trust, as Decode would.
+func checkFragment(coder Coder, frag []byte) error {
+	if err := VerifyFragmentChecksum(frag); err != nil && !errors.Is(err, ErrMethodNotImplemented) {
+		return err
+	}
+	if coder.IsInvalidFragment(frag) {
+		return fmt.Errorf("fragment not valid for %v backend: %w", coder.Parameters().Name, ErrBadHeader)
+	}
+	return nil
+}
+
+// insufficientFragments reports that only have of the k fragments needed
+// for a segment could be read, and why.
+func insufficientFragments(segment int64, have, k int, failures []ArchiveError) error {
+	msg := fmt.Sprintf("segment %d: %d of the %d fragments needed", segment, have, k)
+	if len(failures) > 0 {
+		reasons := make([]string, len(failures))
+		for i, failure := range failures {
+			reasons[i] = failure.Error()
+		}
+		msg += " (" + strings.Join(reasons, "; ") + ")"
+	}
+	return fmt.Errorf("%s: %w", msg, ErrInsufficientFragments)
+}
+
+// skipFragment reads past the next fragment in reader.
+func skipFragment(reader io.Reader) error {
+	header := make([]byte, FragmentHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	info, err := ParseFragmentInfo(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(ioutil.Discard, reader, int64(info.Size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// memArchive is a fragment archive held in memory.
+type memArchive struct {
+	bytes.Buffer
+}
+
+func (a *memArchive) Close() error {
+	return nil
+}
+
+// writeArchives encodes object in segments of segmentSize, returning the
+// contents of each fragment archive.
+func writeArchives(t *testing.T, backend *Backend, object []byte, segmentSize int) [][]byte {
+	n := backend.K + backend.M
+	archives := make([]*memArchive, n)
+	writers := make([]io.WriteCloser, n)
+	for i := range archives {
+		archives[i] = &memArchive{}
+		writers[i] = archives[i]
+	}
+	writer := &ECWriter{Backend: backend, Writers: writers, SegmentSize: segmentSize}
+	if _, err := writer.Write(object); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	contents := make([][]byte, n)
+	for i, archive := range archives {
+		contents[i] = archive.Bytes()
+	}
+	return contents
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+var errUnreadable = errors.New("unreadable")
+
+func TestReader(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2, ChecksumType: ChecksumCRC32}
+	backend := initGoBackend(t, params)
+	object := make([]byte, 4321)
+	rand.Read(object)
+	archives := writeArchives(t, &backend, object, 1000)
+	fragSize, err := backend.FragmentSize(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragSize += FragmentHeaderSize // the last, shorter one aside
+
+	// corrupt returns a copy of archive with the payload of fragment seg
+	// altered.
+	corrupt := func(archive []byte, seg int) []byte {
+		archive = append([]byte(nil), archive...)
+		archive[seg*fragSize+FragmentHeaderSize] ^= 0xff
+		return archive
+	}
+	// badHeader returns a copy of archive with the header of fragment seg
+	// altered.
+	badHeader := func(archive []byte, seg int) []byte {
+		archive = append([]byte(nil), archive...)
+		archive[seg*fragSize] ^= 0xff
+		return archive
+	}
+
+	for _, tc := range []struct {
+		name     string
+		archives map[int]io.Reader // replacing the intact archives
+		failed   []int
+	}{
+		{"intact", nil, nil},
+		{"unreadable", map[int]io.Reader{0: failingReader{errUnreadable}, 3: failingReader{errUnreadable}}, []int{0, 3}},
+		{"truncated", map[int]io.Reader{1: bytes.NewReader(archives[1][:2*fragSize+10])}, []int{1}},
+		{"truncated between fragments", map[int]io.Reader{2: bytes.NewReader(archives[2][:3*fragSize])}, []int{2}},
+		{"empty", map[int]io.Reader{2: bytes.NewReader(nil)}, []int{2}},
+		{"corrupt", map[int]io.Reader{0: bytes.NewReader(corrupt(archives[0], 3))}, []int{0}},
+		{"bad headers", map[int]io.Reader{
+			1: bytes.NewReader(badHeader(archives[1], 1)),
+			3: bytes.NewReader(badHeader(archives[3], 4)),
+		}, []int{1, 3}},
+		{"missing", map[int]io.Reader{
+			0: failingReader{errUnreadable},
+			1: bytes.NewReader(archives[1][:3*fragSize]),
+		}, []int{0, 1}},
+	} {
+		readers := make([]io.Reader, len(archives))
+		for i, archive := range archives {
+			if r, ok := tc.archives[i]; ok {
+				readers[i] = r
+			} else {
+				readers[i] = bytes.NewReader(archive)
+			}
+		}
+		reader := backend.NewReader(readers)
+		got, err := ioutil.ReadAll(iotest.OneByteReader(reader))
+		if err != nil {
+			t.Errorf("%v: Error reading: %v", tc.name, err)
+		} else if !bytes.Equal(got, object) {
+			t.Errorf("%v: Expected %d bytes back, got %d that differ", tc.name, len(object), len(got))
+		}
+		failures := reader.(*ECReader).Failures()
+		if len(failures) != len(tc.failed) {
+			t.Errorf("%v: Expected archives %v to fail, got %v", tc.name, tc.failed, failures)
+			continue
+		}
+		for i, failure := range failures {
+			if failure.Index != tc.failed[i] {
+				t.Errorf("%v: Expected archives %v to fail, got %v", tc.name, tc.failed, failures)
+			}
+		}
+	}
+
+	// With three archives gone, segments after the first can't be read.
+	readers := make([]io.Reader, len(archives))
+	for i, archive := range archives {
+		readers[i] = bytes.NewReader(archive)
+	}
+	readers[0] = failingReader{errUnreadable}
+	readers[2] = bytes.NewReader(corrupt(archives[2], 1))
+	readers[5] = bytes.NewReader(archives[5][:fragSize])
+	got, err := ioutil.ReadAll(backend.NewReader(readers))
+	if !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+	if !bytes.Equal(got, object[:1000]) {
+		t.Errorf("Expected the first segment back, got %d bytes", len(got))
+	}
+}
+
+func TestReaderChecksumTypes(t *testing.T) {
+	object := make([]byte, 4321)
+	rand.Read(object)
+	for _, checksum := range []ChecksumType{ChecksumNone, ChecksumMD5} {
+		params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2, ChecksumType: checksum}
+		backend := initGoBackend(t, params)
+		archives := writeArchives(t, &backend, object, 1000)
+
+		readers := make([]io.Reader, len(archives))
+		readersAt := make([]io.ReaderAt, len(archives))
+		for i, archive := range archives {
+			readers[i] = bytes.NewReader(archive)
+			readersAt[i] = bytes.NewReader(archive)
+		}
+		reader := backend.NewReader(readers)
+		if got, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(got, object) {
+			t.Errorf("%v: Error reading: %v", checksum, err)
+		}
+		if failures := reader.(*ECReader).Failures(); len(failures) > 0 {
+			t.Errorf("%v: Unexpected failures: %v", checksum, failures)
+		}
+		readerAt := backend.NewReaderAt(readersAt, int64(len(object)), 1000)
+		got := make([]byte, 2000)
+		if _, err := readerAt.ReadAt(got, 1500); err != nil || !bytes.Equal(got, object[1500:3500]) {
+			t.Errorf("%v: Error reading at 1500: %v", checksum, err)
+		}
+		if failures := readerAt.Failures(); len(failures) > 0 {
+			t.Errorf("%v: Unexpected failures: %v", checksum, failures)
+		}
+	}
+}
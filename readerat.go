@@ -0,0 +1,244 @@
+package erasurecode
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// ECReaderAt gives random access to an object stored in fragment archives
+// written in segments of SegmentSize bytes, as an ECWriter with that
+// SegmentSize writes them. As every fragment but the last in an archive is
+// the same size, the fragments holding any byte range of the object can be
+// found without reading those before them: ReadAt reads and decodes just
+// the segments the range touches.
+//
+// As with ECReader, each segment is decoded from the first K healthy
+// archives, and an archive that can't be read or gives a bad fragment is
+// failed and not used again. It is safe to call ReadAt from several
+// goroutines at once.
+//
+// Backend must have a FragmentSize method, as Backend does, to locate the
+// fragments.
+type ECReaderAt struct {
+	Backend     Coder
+	Archives    []io.ReaderAt
+	ObjectSize  int64
+	SegmentSize int64
+
+	mu     sync.Mutex
+	failed map[int]error // by position in Archives
+}
+
+// NewReaderAt returns an ECReaderAt for the object of objectSize bytes
+// stored in archives in segments of segmentSize.
+func (backend *Backend) NewReaderAt(archives []io.ReaderAt, objectSize, segmentSize int64) *ECReaderAt {
+	return &ECReaderAt{
+		Backend:     backend,
+		Archives:    archives,
+		ObjectSize:  objectSize,
+		SegmentSize: segmentSize,
+	}
+}
+
+// NewReadSeeker is NewReaderAt, wrapped in an io.SectionReader for use as
+// an io.ReadSeeker, as http.ServeContent wants.
+func (backend *Backend) NewReadSeeker(archives []io.ReaderAt, objectSize, segmentSize int64) io.ReadSeeker {
+	return io.NewSectionReader(backend.NewReaderAt(archives, objectSize, segmentSize), 0, objectSize)
+}
+
+// FragmentRange translates the byte range of length bytes at offset in an
+// object into the range of each of its fragment archives holding the
+// segments it touches, as Swift's proxy does for ranged GETs. It assumes
+// the object was written in segments of segmentSize; if the range touches
+// the last segment, the fragment range may run past the end of the
+// archives, as that fragment is shorter.
+func (backend *Backend) FragmentRange(offset, length, segmentSize int64) (fragOffset, fragLength int64, err error) {
+	if offset < 0 || length < 0 || segmentSize <= 0 || segmentSize > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("invalid range %d+%d or segment size %d: %w",
+			offset, length, segmentSize, ErrInvalidParams)
+	}
+	fragSize, err := backend.FragmentSize(int(segmentSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	archiveFragSize := int64(FragmentHeaderSize + fragSize)
+	first := offset / segmentSize
+	if length == 0 {
+		return first * archiveFragSize, 0, nil
+	}
+	last := (offset + length - 1) / segmentSize
+	return first * archiveFragSize, (last - first + 1) * archiveFragSize, nil
+}
+
+// ReadAt reads len(p) bytes of the object, starting at off.
+func (r *ECReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d: %w", off, ErrInvalidArgument)
+	}
+	if r.SegmentSize <= 0 {
+		return 0, fmt.Errorf("invalid segment size %d: %w", r.SegmentSize, ErrInvalidParams)
+	}
+	if off >= r.ObjectSize {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := off + int64(len(p))
+	if end > r.ObjectSize {
+		end = r.ObjectSize
+	}
+	fullSize, err := fragmentSize(r.Backend, r.SegmentSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	var data []byte
+	for seg := off / r.SegmentSize; seg*r.SegmentSize < end; seg++ {
+		if data, err = r.readSegment(seg, int64(FragmentHeaderSize+fullSize), data); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[off+int64(n)-seg*r.SegmentSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Failures describes the archives that have failed so far, in order.
+func (r *ECReaderAt) Failures() []ArchiveError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failures := make([]ArchiveError, 0, len(r.failed))
+	for i, err := range r.failed {
+		failures = append(failures, ArchiveError{Index: i, Err: err})
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Index < failures[j].Index
+	})
+	return failures
+}
+
+// readSegment reads segment seg's fragments, each archiveFragSize bytes
+// unless it is the last, and decodes them into dst's storage if its
+// capacity allows.
+func (r *ECReaderAt) readSegment(seg, archiveFragSize int64, dst []byte) ([]byte, error) {
+	dataSize := r.ObjectSize - seg*r.SegmentSize
+	if dataSize > r.SegmentSize {
+		dataSize = r.SegmentSize
+	}
+	fragSize := archiveFragSize
+	if dataSize < r.SegmentSize {
+		size, err := fragmentSize(r.Backend, dataSize)
+		if err != nil {
+			return nil, err
+		}
+		fragSize = int64(FragmentHeaderSize + size)
+	}
+
+	k := r.Backend.Parameters().K
+	frags := make([][]byte, 0, k)
+	defer func() {
+		for _, frag := range frags {
+			fragmentBuffers.Put(frag)
+		}
+	}()
+	seen := make(map[int]bool, k)
+	for i, archive := range r.Archives {
+		if len(frags) == k {
+			break
+		}
+		if r.hasFailed(i) {
+			continue
+		}
+		frag := fragmentBuffers.Get(int(fragSize))
+		if err := r.readFragment(archive, frag, seg*archiveFragSize, dataSize); err != nil {
+			fragmentBuffers.Put(frag)
+			r.fail(i, fmt.Errorf("segment %d: %w", seg, err))
+			continue
+		}
+		// Two archives with the same fragment are no use together.
+		index := GetFragmentInfo(frag).Index
+		if seen[index] {
+			fragmentBuffers.Put(frag)
+			continue
+		}
+		seen[index] = true
+		frags = append(frags, frag)
+	}
+	if len(frags) < k {
+		return nil, insufficientFragments(seg, len(frags), k, r.Failures())
+	}
+
+	var data []byte
+	var err error
+	if decoder, ok := r.Backend.(bufferDecoder); ok {
+		data, err = decoder.DecodeTo(frags, dst)
+	} else {
+		data, err = r.Backend.Decode(frags)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %w", seg, err)
+	}
+	return data, nil
+}
+
+// readFragment fills frag from archive at off, checking that it is the
+// fragment of a segment of dataSize bytes.
+func (r *ECReaderAt) readFragment(archive io.ReaderAt, frag []byte, off, dataSize int64) error {
+	n, err := archive.ReadAt(frag, off)
+	if n == len(frag) {
+		err = nil
+	} else if err == io.EOF || err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkFragment(r.Backend, frag); err != nil {
+		return err
+	}
+	if info := GetFragmentInfo(frag); info.OrigDataSize != uint64(dataSize) || FragmentHeaderSize+info.Size != len(frag) {
+		return fmt.Errorf("fragment of %d bytes holding %d bytes of data where %d bytes holding %d expected: %w",
+			FragmentHeaderSize+info.Size, info.OrigDataSize, len(frag), dataSize, ErrBadHeader)
+	}
+	return nil
+}
+
+// fragmentSize returns the payload size of the fragments coder makes from a
+// segment of dataLen bytes.
+func fragmentSize(coder Coder, dataLen int64) (int, error) {
+	if dataLen > math.MaxInt32 {
+		return 0, fmt.Errorf("segment size %d too large: %w", dataLen, ErrInvalidParams)
+	}
+	if c, ok := coder.(interface {
+		FragmentSize(dataLen int) (int, error)
+	}); ok {
+		return c.FragmentSize(int(dataLen))
+	}
+	return 0, fmt.Errorf("%v backend can't report fragment sizes: %w", coder.Parameters().Name, ErrMethodNotImplemented)
+}
+
+func (r *ECReaderAt) hasFailed(index int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed[index] != nil
+}
+
+// fail records the first error for the archive at index.
+func (r *ECReaderAt) fail(index int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed[index] != nil {
+		return
+	}
+	if r.failed == nil {
+		r.failed = make(map[int]error)
+	}
+	r.failed[index] = err
+}
@@ -0,0 +1,194 @@
+package erasurecode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// recordingReaderAt notes the ranges read from it.
+type recordingReaderAt struct {
+	r      io.ReaderAt
+	mu     sync.Mutex
+	ranges [][2]int64
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	r.ranges = append(r.ranges, [2]int64{off, int64(len(p))})
+	r.mu.Unlock()
+	return r.r.ReadAt(p, off)
+}
+
+func TestFragmentRange(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend := initGoBackend(t, params)
+	fragSize, err := backend.FragmentSize(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragSize += FragmentHeaderSize
+	for _, tc := range []struct {
+		offset, length    int64
+		fragOffset, frags int64
+	}{
+		{0, 1, 0, 1},
+		{0, 1000, 0, 1},
+		{0, 1001, 0, 2},
+		{999, 2, 0, 2},
+		{1000, 1000, 1, 1},
+		{2500, 0, 2, 0},
+		{2500, 3000, 2, 4},
+	} {
+		fragOffset, fragLength, err := backend.FragmentRange(tc.offset, tc.length, 1000)
+		if err != nil {
+			t.Errorf("Error translating %d+%d: %v", tc.offset, tc.length, err)
+		} else if fragOffset != tc.fragOffset*int64(fragSize) || fragLength != tc.frags*int64(fragSize) {
+			t.Errorf("Expected %d+%d to map to fragments %d+%d, got bytes %d+%d",
+				tc.offset, tc.length, tc.fragOffset, tc.frags, fragOffset, fragLength)
+		}
+	}
+	if _, _, err := backend.FragmentRange(-1, 1, 1000); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for a negative offset, got %v", err)
+	}
+	if _, _, err := backend.FragmentRange(0, 1, 0); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for a zero segment size, got %v", err)
+	}
+	if _, _, err := backend.FragmentRange(0, 1, math.MaxInt32+1); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for a segment size over MaxInt32, got %v", err)
+	}
+}
+
+func TestReaderAt(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2, ChecksumType: ChecksumCRC32}
+	backend := initGoBackend(t, params)
+	object := make([]byte, 4321)
+	rand.Read(object)
+	archives := writeArchives(t, &backend, object, 1000)
+	recorders := make([]*recordingReaderAt, len(archives))
+	readers := make([]io.ReaderAt, len(archives))
+	for i, archive := range archives {
+		recorders[i] = &recordingReaderAt{r: bytes.NewReader(archive)}
+		readers[i] = recorders[i]
+	}
+	reader := backend.NewReaderAt(readers, int64(len(object)), 1000)
+
+	for _, tc := range []struct {
+		offset int64
+		length int
+	}{
+		{0, 10},
+		{0, 1000},
+		{995, 10},
+		{1500, 2000},
+		{4000, 321},
+		{4300, 100}, // past the end
+		{0, 5000},
+	} {
+		for _, r := range recorders {
+			r.ranges = nil
+		}
+		p := make([]byte, tc.length)
+		n, err := reader.ReadAt(p, tc.offset)
+		want := object[tc.offset:]
+		if len(want) > tc.length {
+			want = want[:tc.length]
+		}
+		if n != len(want) || !bytes.Equal(p[:n], want) {
+			t.Errorf("%d+%d: Expected %d bytes, got %d that differ", tc.offset, tc.length, len(want), n)
+		}
+		if (n < tc.length) != (err == io.EOF) || (err != nil && err != io.EOF) {
+			t.Errorf("%d+%d: Unexpected error %v", tc.offset, tc.length, err)
+		}
+
+		// Only the fragments needed are read, from the first K archives.
+		fragOffset, fragLength, err := backend.FragmentRange(tc.offset, int64(len(want)), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range recorders {
+			if i >= params.K {
+				if len(r.ranges) > 0 {
+					t.Errorf("%d+%d: Archive %d read unnecessarily: %v", tc.offset, tc.length, i, r.ranges)
+				}
+				continue
+			}
+			for _, rng := range r.ranges {
+				if rng[0] < fragOffset || rng[0]+rng[1] > fragOffset+fragLength {
+					t.Errorf("%d+%d: Archive %d read at %d+%d, outside %d+%d",
+						tc.offset, tc.length, i, rng[0], rng[1], fragOffset, fragLength)
+				}
+			}
+		}
+	}
+	if n, err := reader.ReadAt(make([]byte, 1), int64(len(object))); n != 0 || err != io.EOF {
+		t.Errorf("Expected EOF reading at the end, got %d, %v", n, err)
+	}
+	if len(reader.Failures()) > 0 {
+		t.Errorf("Unexpected failures: %v", reader.Failures())
+	}
+}
+
+func TestReaderAtFailures(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2, ChecksumType: ChecksumCRC32}
+	backend := initGoBackend(t, params)
+	object := make([]byte, 4321)
+	rand.Read(object)
+	archives := writeArchives(t, &backend, object, 1000)
+	fragSize, err := backend.FragmentSize(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragSize += FragmentHeaderSize
+
+	corrupted := append([]byte(nil), archives[0]...)
+	corrupted[2*fragSize+FragmentHeaderSize] ^= 0xff
+	readers := []io.ReaderAt{
+		bytes.NewReader(corrupted),
+		bytes.NewReader(archives[1][:3*fragSize]),
+		bytes.NewReader(archives[2]),
+		bytes.NewReader(archives[3]),
+		bytes.NewReader(archives[4]),
+		bytes.NewReader(archives[5]),
+	}
+	seeker := backend.NewReadSeeker(readers, int64(len(object)), 1000)
+
+	// Segments 0 and 1 are intact.
+	p := make([]byte, 100)
+	if _, err := seeker.Seek(1950, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(seeker, p); err != nil || !bytes.Equal(p, object[1950:2050]) {
+		t.Errorf("Error reading 1950+100: %v", err)
+	}
+	// Segment 2 is corrupt in archive 0, and segment 3 missing from 1.
+	if _, err := seeker.Seek(-400, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(seeker)
+	if err != nil || !bytes.Equal(rest, object[len(object)-400:]) {
+		t.Errorf("Error reading the last 400 bytes: %v", err)
+	}
+
+	readerAt := backend.NewReaderAt(readers, int64(len(object)), 1000)
+	if _, err := readerAt.ReadAt(make([]byte, 3000), 1000); err != nil {
+		t.Errorf("Error reading 1000+3000: %v", err)
+	}
+	failures := readerAt.Failures()
+	if len(failures) != 2 || failures[0].Index != 0 || !errors.Is(failures[0], ErrBadChecksum) ||
+		failures[1].Index != 1 || !errors.Is(failures[1], io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected failures: %v", failures)
+	}
+
+	// With a third archive gone, nothing can be read.
+	readerAt.Archives[2] = bytes.NewReader(nil)
+	_, err = readerAt.ReadAt(make([]byte, 10), 0)
+	if !errors.Is(err, ErrInsufficientFragments) {
+		t.Errorf("Expected ErrInsufficientFragments, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package erasurecode
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrBackendRegistered is returned by RegisterBackend when the name or ID
+// is already taken.
+var ErrBackendRegistered = errors.New("backend already registered")
+
+// BackendFactory describes a backend added with RegisterBackend.
+type BackendFactory struct {
+	// ID identifies the backend in fragment headers; GetFragmentInfo uses
+	// it to fill in BackendName.
+	ID BackendID
+	// New creates a Coder for the given params. If nil, the backend is
+	// taken to be one liberasurecode provides under ID, but that this
+	// package does not know by name.
+	New func(params Params) (Coder, error)
+	// Capabilities is reported by BackendCapabilities; its Name,
+	// Available and Unavailable fields are filled in there.
+	Capabilities Capabilities
+}
+
+var registry struct {
+	sync.RWMutex
+	names  []string // in registration order
+	byName map[string]BackendFactory
+}
+
+// RegisterBackend makes a backend beyond KnownBackends available to
+// InitBackend, BackendIsAvailable and AvailableBackends. Neither name nor
+// factory.ID may clash with a built-in or previously registered backend.
+//
+// Besides the Coder methods, the Coder returned by factory.New may
+// implement any of
+//
+//	FragmentsNeeded(want, exclude []int) ([]int, error)
+//	AlignedDataSize(dataLen int) (int, error)
+//	MinimumEncodeSize() (int, error)
+//	FragmentSize(dataLen int) (int, error)
+//
+// to support the Backend methods of the same name. Without them,
+// FragmentsNeeded picks any K fragments and the size methods return an
+// error wrapping ErrMethodNotImplemented.
+func RegisterBackend(name string, factory BackendFactory) error {
+	if name == "" {
+		return fmt.Errorf("backend name must not be empty: %w", ErrInvalidParams)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := builtinNameToID(name); ok {
+		return fmt.Errorf("%q is a built-in backend: %w", name, ErrBackendRegistered)
+	}
+	if builtinIDToName(factory.ID) != "" {
+		return fmt.Errorf("backend ID %d is used by %v: %w",
+			factory.ID, builtinIDToName(factory.ID), ErrBackendRegistered)
+	}
+	if _, ok := registry.byName[name]; ok {
+		return fmt.Errorf("%q is already registered: %w", name, ErrBackendRegistered)
+	}
+	for _, other := range registry.names {
+		if registry.byName[other].ID == factory.ID {
+			return fmt.Errorf("backend ID %d is used by %v: %w", factory.ID, other, ErrBackendRegistered)
+		}
+	}
+	if registry.byName == nil {
+		registry.byName = make(map[string]BackendFactory)
+	}
+	registry.byName[name] = factory
+	registry.names = append(registry.names, name)
+	return nil
+}
+
+// unregisterBackend undoes RegisterBackend; it's only for tests.
+func unregisterBackend(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.byName, name)
+	for i, other := range registry.names {
+		if other == name {
+			registry.names = append(registry.names[:i], registry.names[i+1:]...)
+			break
+		}
+	}
+}
+
+func registeredBackend(name string) (BackendFactory, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	factory, ok := registry.byName[name]
+	return factory, ok
+}
+
+func registeredBackendNames() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]string(nil), registry.names...)
+}
+
+func registeredIDToName(id BackendID) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, name := range registry.names {
+		if registry.byName[name].ID == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// coderEngine adapts a Coder from a registered factory to the engine
+// interface.
+type coderEngine struct {
+	coder  Coder
+	params Params
+}
+
+func (e coderEngine) encode(data []byte) ([][]byte, error) {
+	return e.coder.Encode(data)
+}
+
+func (e coderEngine) decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	return e.coder.Decode(frags)
+}
+
+func (e coderEngine) reconstruct(frags [][]byte, fragIndex int) ([]byte, error) {
+	return e.coder.Reconstruct(frags, fragIndex)
+}
+
+func (e coderEngine) isInvalidFragment(frag []byte) bool {
+	return e.coder.IsInvalidFragment(frag)
+}
+
+func (e coderEngine) fragmentsNeeded(want, exclude []int) ([]int, error) {
+	if c, ok := e.coder.(interface {
+		FragmentsNeeded(want, exclude []int) ([]int, error)
+	}); ok {
+		return c.FragmentsNeeded(want, exclude)
+	}
+	return anyKFragments(e.params, want, exclude)
+}
+
+func (e coderEngine) alignedDataSize(dataLen int) (int, error) {
+	if c, ok := e.coder.(interface {
+		AlignedDataSize(dataLen int) (int, error)
+	}); ok {
+		return c.AlignedDataSize(dataLen)
+	}
+	return 0, newError("get_aligned_data_size", e.params, -errnoEECMETHODNOTIMPL)
+}
+
+func (e coderEngine) minimumEncodeSize() (int, error) {
+	if c, ok := e.coder.(interface {
+		MinimumEncodeSize() (int, error)
+	}); ok {
+		return c.MinimumEncodeSize()
+	}
+	return 0, newError("get_minimum_encode_size", e.params, -errnoEECMETHODNOTIMPL)
+}
+
+func (e coderEngine) fragmentSize(dataLen int) (int, error) {
+	if c, ok := e.coder.(interface {
+		FragmentSize(dataLen int) (int, error)
+	}); ok {
+		return c.FragmentSize(dataLen)
+	}
+	return 0, newError("get_fragment_size", e.params, -errnoEECMETHODNOTIMPL)
+}
+
+func (e coderEngine) close() error {
+	return e.coder.Close()
+}
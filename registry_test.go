@@ -0,0 +1,104 @@
+package erasurecode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterBackend(t *testing.T) {
+	newFake := func(params Params) (Coder, error) {
+		return &fakeCoder{params: params}, nil
+	}
+	for _, tc := range []struct {
+		name string
+		id   BackendID
+	}{
+		{"null", 200},
+		{"isa_l_rs_vand", 200},
+		{"fake_backend", backendIsaLRSVand},
+		{"", 200},
+	} {
+		err := RegisterBackend(tc.name, BackendFactory{ID: tc.id, New: newFake})
+		if err == nil {
+			unregisterBackend(tc.name)
+			t.Errorf("Expected error registering %q with ID %v", tc.name, tc.id)
+		}
+	}
+
+	if err := RegisterBackend("fake_backend", BackendFactory{ID: 200, New: newFake}); err != nil {
+		t.Fatalf("Error registering backend: %v", err)
+	}
+	defer unregisterBackend("fake_backend")
+	if err := RegisterBackend("fake_backend", BackendFactory{ID: 201, New: newFake}); !errors.Is(err, ErrBackendRegistered) {
+		t.Errorf("Expected ErrBackendRegistered for duplicate name, got %v", err)
+	}
+	if err := RegisterBackend("other_fake", BackendFactory{ID: 200, New: newFake}); !errors.Is(err, ErrBackendRegistered) {
+		unregisterBackend("other_fake")
+		t.Errorf("Expected ErrBackendRegistered for duplicate ID, got %v", err)
+	}
+
+	if !BackendIsAvailable("fake_backend") {
+		t.Errorf("Expected registered backend to be available")
+	}
+	found := false
+	for _, name := range AvailableBackends() {
+		found = found || name == "fake_backend"
+	}
+	if !found {
+		t.Errorf("Expected fake_backend in %v", AvailableBackends())
+	}
+
+	params := Params{Name: "fake_backend", K: 2, M: 1}
+	backend, err := InitBackend(params)
+	if err != nil {
+		t.Fatalf("Error creating registered backend: %v", err)
+	}
+	frags, err := backend.Encode([]byte("abc"))
+	if err != nil || len(frags) != 3 {
+		t.Errorf("Expected 3 fragments, got %v (%v)", len(frags), err)
+	}
+	needed, err := backend.FragmentsNeeded(nil, []int{0})
+	if err != nil || len(needed) != 2 || needed[0] != 1 || needed[1] != 2 {
+		t.Errorf("Expected [1 2] needed, got %v (%v)", needed, err)
+	}
+	if _, err := backend.FragmentSize(10); !errors.Is(err, ErrMethodNotImplemented) {
+		t.Errorf("Expected ErrMethodNotImplemented, got %v", err)
+	}
+	coder := backend.current().(coderEngine).coder.(*fakeCoder)
+	if err := backend.Close(); err != nil || !coder.closed {
+		t.Errorf("Expected Close to close the coder, got %v", err)
+	}
+
+	// Fragments stamped with the registered ID are reported by name.
+	goBackend := initGoBackend(t, Params{Name: "liberasurecode_rs_vand", K: 2, M: 1})
+	frags, err = goBackend.Encode([]byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags[0][offBackendID] = 200
+	setMetadataChecksum(frags[0], false)
+	if info := GetFragmentInfo(frags[0]); info.BackendName != "fake_backend" || !info.IsValid {
+		t.Errorf("Expected valid fake_backend fragment, got %+v", info)
+	}
+}
+
+func TestRegisterLibecBackend(t *testing.T) {
+	// Without New, the backend is expected to come from liberasurecode,
+	// which knows nothing of ID 201.
+	if err := RegisterBackend("future_backend", BackendFactory{ID: 201}); err != nil {
+		t.Fatalf("Error registering backend: %v", err)
+	}
+	defer unregisterBackend("future_backend")
+	if BackendIsAvailable("future_backend") {
+		t.Errorf("Expected future_backend to be unavailable")
+	}
+	if _, err := InitBackend(Params{Name: "future_backend", K: 2, M: 1}); err == nil {
+		t.Errorf("Expected error creating future_backend")
+	}
+	if id, err := nameToID("future_backend"); err != nil || id != 201 {
+		t.Errorf("Expected ID 201, got %v (%v)", id, err)
+	}
+	if name := idToName(201); name != "future_backend" {
+		t.Errorf("Expected future_backend, got %q", name)
+	}
+}
@@ -0,0 +1,444 @@
+package erasurecode
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+)
+
+// Backend versions liberasurecode stamps into fragment headers.
+var (
+	liberasurecodeRSVandVersion = Version{1, 0, 0}
+	isaLRSVandVersion           = Version{2, 13, 0}
+)
+
+// maxFragments is liberasurecode's EC_MAX_FRAGMENTS.
+const maxFragments = 32
+
+// columnChunk bounds how much of each block the pure-Go codes process
+// between checks for cancellation.
+const columnChunk = 64 << 10
+
+// goEngines lists the backends with a pure-Go implementation. They're used
+// whenever liberasurecode doesn't provide the backend itself -- notably in
+// builds without cgo.
+var goEngines = map[BackendID]func(Params) (engine, error){
+	backendLiberasurecodeRSVand: func(params Params) (engine, error) {
+		return newRSEngine(params, backendLiberasurecodeRSVand, liberasurecodeRSVandVersion,
+			gf16, liberasurecodeRSVandMatrix)
+	},
+	backendIsaLRSVand: func(params Params) (engine, error) {
+		return newRSEngine(params, backendIsaLRSVand, isaLRSVandVersion,
+			gf8, isaLRSVandMatrix)
+	},
+}
+
+// rsEngine is a pure-Go Reed-Solomon implementation that reads and writes
+// fragments byte-for-byte identical to liberasurecode's.
+type rsEngine struct {
+	params         Params
+	id             BackendID
+	backendVersion uint32
+	libecVersion   uint32
+	checksumType   ChecksumType
+	legacyCRC      bool
+	field          *galoisField
+	parity         [][]int // the parity rows of the generator matrix
+}
+
+func newRSEngine(params Params, id BackendID, version Version,
+	field *galoisField, matrix func(k, m int) [][]int) (*rsEngine, error) {
+	if params.K <= 0 || params.M <= 0 || params.K+params.M > maxFragments {
+		return nil, newError("instance_create", params, -errnoEINVALIDPARAMS)
+	}
+	ct := params.ChecksumType
+	if ct == 0 {
+		ct = ChecksumCRC32
+	}
+	return &rsEngine{
+		params:         params,
+		id:             id,
+		backendVersion: version.pack(),
+		libecVersion:   GetVersion().pack(),
+		checksumType:   ct,
+		legacyCRC:      params.CRCVariant.legacy(),
+		field:          field,
+		parity:         matrix(params.K, params.M),
+	}, nil
+}
+
+func (e *rsEngine) wordSize() int {
+	return int(e.field.w / 8)
+}
+
+func (e *rsEngine) close() error {
+	return nil
+}
+
+func (e *rsEngine) alignedDataSize(dataLen int) (int, error) {
+	multiple := e.params.K * e.wordSize()
+	return (dataLen + multiple - 1) / multiple * multiple, nil
+}
+
+func (e *rsEngine) minimumEncodeSize() (int, error) {
+	return e.alignedDataSize(1)
+}
+
+func (e *rsEngine) fragmentSize(dataLen int) (int, error) {
+	aligned, _ := e.alignedDataSize(dataLen)
+	return aligned / e.params.K, nil
+}
+
+func (e *rsEngine) fragmentsNeeded(want, exclude []int) ([]int, error) {
+	return anyKFragments(e.params, want, exclude)
+}
+
+// anyKFragments follows liberasurecode's generic fragments_needed, for
+// codes where any K fragments will do: take the first K that aren't
+// missing.
+func anyKFragments(params Params, want, exclude []int) ([]int, error) {
+	missing := make(map[int]bool, len(want)+len(exclude))
+	for _, idx := range append(append([]int{}, want...), exclude...) {
+		missing[idx] = true
+	}
+	needed := make([]int, 0, params.K)
+	for idx := 0; idx < params.K+params.M && len(needed) < params.K; idx++ {
+		if !missing[idx] {
+			needed = append(needed, idx)
+		}
+	}
+	if len(needed) < params.K {
+		return nil, newError("fragments_needed", params, -errnoEPERM)
+	}
+	return needed, nil
+}
+
+// finishFragment fills in the header for a fragment whose payload has been
+// written, as liberasurecode's add_fragment_metadata does.
+func (e *rsEngine) finishFragment(frag []byte, index int, origDataSize uint64) {
+	payload := frag[FragmentHeaderSize:]
+	h := fragmentHeader{
+		index:          uint32(index),
+		size:           uint32(len(payload)),
+		origDataSize:   origDataSize,
+		checksumType:   uint8(e.checksumType),
+		backendID:      uint8(e.id),
+		backendVersion: e.backendVersion,
+		magic:          fragmentHeaderMagic,
+		libecVersion:   e.libecVersion,
+	}
+	if e.checksumType == ChecksumCRC32 {
+		h.checksum[0] = crc32.ChecksumIEEE(payload)
+	}
+	h.put(frag)
+	setMetadataChecksum(frag, e.legacyCRC)
+}
+
+func (e *rsEngine) encode(data []byte) ([][]byte, error) {
+	return e.encodeContext(context.Background(), data)
+}
+
+func (e *rsEngine) encodeContext(ctx context.Context, data []byte) ([][]byte, error) {
+	frags := make([][]byte, e.params.K+e.params.M)
+	if err := e.encodeInto(ctx, data, frags); err != nil {
+		return nil, err
+	}
+	return frags, nil
+}
+
+func (e *rsEngine) encodeTo(data []byte, dst [][]byte) error {
+	return e.encodeInto(context.Background(), data, dst)
+}
+
+// encodeInto encodes data into the buffers in dst, as for EncodeTo.
+func (e *rsEngine) encodeInto(ctx context.Context, data []byte, dst [][]byte) error {
+	k := e.params.K
+	if len(data) == 0 {
+		frags, err := encodeEmpty(e)
+		if err != nil {
+			return err
+		}
+		copyFragments(dst, frags)
+		return nil
+	}
+	blockSize, _ := e.fragmentSize(len(data))
+	blocks := make([][]byte, len(dst))
+	for i := range dst {
+		dst[i] = resize(dst[i], FragmentHeaderSize+blockSize)
+		blocks[i] = dst[i][FragmentHeaderSize:]
+	}
+	for i, block := range blocks {
+		n := 0
+		if offset := i * blockSize; i < k && offset < len(data) {
+			n = copy(block, data[offset:])
+		}
+		zero(block[n:])
+	}
+	if err := e.encodeBlocks(ctx, blocks); err != nil {
+		return err
+	}
+	for i, frag := range dst {
+		e.finishFragment(frag, i, uint64(len(data)))
+	}
+	return nil
+}
+
+// encodeBlocks computes the parity blocks blocks[K:] from the data blocks
+// blocks[:K]. The parity blocks must be zeroed.
+func (e *rsEngine) encodeBlocks(ctx context.Context, blocks [][]byte) error {
+	k := e.params.K
+	return forColumns(ctx, len(blocks[0]), func(lo, hi int) {
+		for r, row := range e.parity {
+			for j, c := range row {
+				e.field.mulAdd(blocks[k+r][lo:hi], blocks[j][lo:hi], c)
+			}
+		}
+	})
+}
+
+// forColumns splits [0, size) into ranges of at most columnChunk bytes and
+// calls fn on each in turn, stopping early if ctx is done. Every code works
+// column by column, so this changes nothing but how soon cancellation takes
+// effect.
+func forColumns(ctx context.Context, size int, fn func(lo, hi int)) error {
+	for lo := 0; lo < size; lo += columnChunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hi := lo + columnChunk
+		if hi > size {
+			hi = size
+		}
+		fn(lo, hi)
+	}
+	return nil
+}
+
+// stripe is a set of fragments from the same encode, indexed by fragment
+// index, along with the header values they agree on.
+type stripe struct {
+	blocks       [][]byte // payloads; nil if missing
+	available    []int
+	blockSize    int
+	origDataSize uint64
+}
+
+// collect sorts frags into a stripe, checking headers the way
+// liberasurecode's decode does.
+func (e *rsEngine) collect(op string, frags [][]byte, forceMetadataChecks bool) (*stripe, error) {
+	k, n := e.params.K, e.params.K+e.params.M
+	if forceMetadataChecks {
+		valid := 0
+		for _, frag := range frags {
+			if len(frag) >= FragmentHeaderSize && isValidHeader(frag) {
+				valid++
+			}
+		}
+		if valid < k {
+			return nil, newError(op, e.params, -errnoEINSUFFFRAGS)
+		}
+	}
+	s := &stripe{blocks: make([][]byte, n), blockSize: -1}
+	for _, frag := range frags {
+		if len(frag) < FragmentHeaderSize {
+			return nil, newError(op, e.params, -errnoEBADHEADER)
+		}
+		h := parseHeader(frag)
+		if h.magic != fragmentHeaderMagic || int(h.index) >= n ||
+			len(frag) < FragmentHeaderSize+int(h.size) {
+			return nil, newError(op, e.params, -errnoEBADHEADER)
+		}
+		if s.blockSize < 0 {
+			s.blockSize = int(h.size)
+			s.origDataSize = h.origDataSize
+		} else if int(h.size) != s.blockSize {
+			return nil, newError(op, e.params, -errnoEBADHEADER)
+		}
+		if s.blocks[h.index] == nil {
+			s.blocks[h.index] = frag[FragmentHeaderSize : FragmentHeaderSize+int(h.size)]
+			s.available = append(s.available, int(h.index))
+		}
+	}
+	if len(s.available) < k {
+		return nil, newError(op, e.params, -errnoEINSUFFFRAGS)
+	}
+	if s.blockSize%e.wordSize() != 0 {
+		return nil, newError(op, e.params, -errnoEBADHEADER)
+	}
+	sort.Ints(s.available)
+	return s, nil
+}
+
+// recoverData fills in any missing data blocks in s.
+func (e *rsEngine) recoverData(ctx context.Context, op string, s *stripe) error {
+	k := e.params.K
+	var missing []int
+	for i := 0; i < k; i++ {
+		if s.blocks[i] == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	// Invert the rows of the generator matrix for the first K available
+	// fragments; the rows of the inverse give each data block as a
+	// combination of those fragments.
+	use := s.available[:k]
+	matrix := make([][]int, k)
+	for i, idx := range use {
+		matrix[i] = make([]int, k)
+		if idx < k {
+			matrix[i][idx] = 1
+		} else {
+			copy(matrix[i], e.parity[idx-k])
+		}
+	}
+	if !e.field.invertMatrix(matrix) {
+		return newError(op, e.params, -errnoEPERM)
+	}
+	recovered := make([][]byte, len(missing))
+	for n := range recovered {
+		recovered[n] = make([]byte, s.blockSize)
+	}
+	err := forColumns(ctx, s.blockSize, func(lo, hi int) {
+		for n, i := range missing {
+			for j, idx := range use {
+				e.field.mulAdd(recovered[n][lo:hi], s.blocks[idx][lo:hi], matrix[i][j])
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for n, i := range missing {
+		s.blocks[i] = recovered[n]
+	}
+	return nil
+}
+
+func (e *rsEngine) decode(frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	return e.decodeContext(context.Background(), frags, forceMetadataChecks)
+}
+
+func (e *rsEngine) decodeContext(ctx context.Context, frags [][]byte, forceMetadataChecks bool) ([]byte, error) {
+	return e.decodeInto(ctx, frags, forceMetadataChecks, nil)
+}
+
+func (e *rsEngine) decodeTo(frags [][]byte, forceMetadataChecks bool, dst []byte) ([]byte, error) {
+	return e.decodeInto(context.Background(), frags, forceMetadataChecks, dst)
+}
+
+// decodeInto decodes frags into dst's storage, if it is large enough.
+func (e *rsEngine) decodeInto(ctx context.Context, frags [][]byte, forceMetadataChecks bool, dst []byte) ([]byte, error) {
+	s, err := e.collect("decode", frags, forceMetadataChecks)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.recoverData(ctx, "decode", s); err != nil {
+		return nil, err
+	}
+	if s.origDataSize > uint64(e.params.K*s.blockSize) {
+		return nil, newError("decode", e.params, -errnoEBADHEADER)
+	}
+	data := resize(dst, int(s.origDataSize))
+	offset := 0
+	for _, block := range s.blocks[:e.params.K] {
+		offset += copy(data[offset:], block)
+	}
+	return data, nil
+}
+
+func (e *rsEngine) reconstruct(frags [][]byte, fragIndex int) ([]byte, error) {
+	return e.reconstructContext(context.Background(), frags, fragIndex)
+}
+
+func (e *rsEngine) reconstructContext(ctx context.Context, frags [][]byte, fragIndex int) ([]byte, error) {
+	rebuilt, err := e.reconstructMany(ctx, frags, []int{fragIndex})
+	if err != nil {
+		return nil, err
+	}
+	return rebuilt[fragIndex], nil
+}
+
+func (e *rsEngine) reconstructTo(frags [][]byte, fragIndex int, dst []byte) ([]byte, error) {
+	if fragIndex < 0 || fragIndex >= e.params.K+e.params.M {
+		return nil, newError("reconstruct_fragment", e.params, -errnoEINVALIDPARAMS)
+	}
+	s, err := e.collect("reconstruct_fragment", frags, false)
+	if err != nil {
+		return nil, err
+	}
+	frag := resize(dst, FragmentHeaderSize+s.blockSize)
+	if err := e.rebuild(context.Background(), s, fragIndex, frag); err != nil {
+		return nil, err
+	}
+	return frag, nil
+}
+
+// reconstructMany rebuilds each of indexes, recovering the data blocks
+// only once.
+func (e *rsEngine) reconstructMany(ctx context.Context, frags [][]byte, indexes []int) (map[int][]byte, error) {
+	for _, fragIndex := range indexes {
+		if fragIndex < 0 || fragIndex >= e.params.K+e.params.M {
+			return nil, newError("reconstruct_fragment", e.params, -errnoEINVALIDPARAMS)
+		}
+	}
+	s, err := e.collect("reconstruct_fragment", frags, false)
+	if err != nil {
+		return nil, err
+	}
+	rebuilt := make(map[int][]byte, len(indexes))
+	for _, fragIndex := range indexes {
+		frag := make([]byte, FragmentHeaderSize+s.blockSize)
+		if err := e.rebuild(ctx, s, fragIndex, frag); err != nil {
+			return nil, err
+		}
+		rebuilt[fragIndex] = frag
+	}
+	return rebuilt, nil
+}
+
+// rebuild writes fragment fragIndex of s into frag, which must have room
+// for its header and payload.
+func (e *rsEngine) rebuild(ctx context.Context, s *stripe, fragIndex int, frag []byte) error {
+	k := e.params.K
+	block := frag[FragmentHeaderSize:]
+	if s.blocks[fragIndex] == nil {
+		if err := e.recoverData(ctx, "reconstruct_fragment", s); err != nil {
+			return err
+		}
+	}
+	if s.blocks[fragIndex] != nil {
+		copy(block, s.blocks[fragIndex])
+	} else {
+		zero(block)
+		err := forColumns(ctx, s.blockSize, func(lo, hi int) {
+			for j, c := range e.parity[fragIndex-k] {
+				e.field.mulAdd(block[lo:hi], s.blocks[j][lo:hi], c)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	e.finishFragment(frag, fragIndex, s.origDataSize)
+	return nil
+}
+
+// isInvalidFragment follows liberasurecode's is_invalid_fragment.
+func (e *rsEngine) isInvalidFragment(frag []byte) bool {
+	if len(frag) < FragmentHeaderSize {
+		return true
+	}
+	h := parseHeader(frag)
+	if h.magic != fragmentHeaderMagic || h.libecVersion > GetVersion().pack() ||
+		!isValidHeader(frag) || len(frag) < FragmentHeaderSize+int(h.size) {
+		return true
+	}
+	if ChecksumType(h.checksumType) == ChecksumCRC32 && verifyPayload(makeFragmentInfo(h), frag) != nil {
+		return true
+	}
+	return BackendID(h.backendID) != e.id || h.backendVersion != e.backendVersion ||
+		h.checksumMismatch == 1 || int(h.index) >= e.params.K+e.params.M
+}
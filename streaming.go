@@ -1,22 +1,75 @@
 package erasurecode
 
-/*
-#cgo pkg-config: erasurecode-1
-#include <liberasurecode/erasurecode.h>
-*/
-import "C"
-
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"sync"
+	"time"
 )
 
+// ECWriter encodes everything written to it, writing one fragment of each
+// segment to each of Writers.
+//
+// If SegmentSize is zero, each call to Write is encoded as a segment of its
+// own, so the layout of the archives depends on how the caller splits up
+// its writes. Otherwise, input is buffered into segments of SegmentSize
+// bytes, as Swift does with ec_object_segment_size: each segment is encoded
+// once full, and any shorter final segment when the writer is closed.
+//
+// If one of Writers fails, nothing more is written to it. Writes keep
+// succeeding so long as Quorum archives remain healthy; once fewer do,
+// Write and Close return a *WriteError. Failures describes any archives
+// that failed either way.
+//
+// By default each fragment is written to each writer in turn before Write
+// returns. If QueueDepth is positive, each writer is instead fed by its own
+// goroutine, so a slow writer only holds up the others once its queue is
+// full. Write may then return before the fragments are written; failures
+// are reported by later calls, and by Close, which waits for the queues to
+// drain.
 type ECWriter struct {
-	Backend *Backend
+	Backend Coder
 	Writers []io.WriteCloser
+	// Buffers supplies the buffers fragments are encoded into, if Backend
+	// has an EncodeTo method as Backend does. If nil, a pool shared by all
+	// ECWriters is used.
+	Buffers *BufferPool
+	// SegmentSize is the number of bytes encoded at a time; see above.
+	SegmentSize int
+	// Quorum is the number of archives that must be written successfully,
+	// from K (enough to decode) to K+M. If zero, it is K+1 (or K+M, if
+	// smaller), as Swift requires.
+	Quorum int
+	// QueueDepth is the number of fragments that may be queued for each
+	// writer; see above.
+	QueueDepth int
+	// WriteTimeout, if positive, is how long a queued writer may lag
+	// before it is failed with ErrWriteTimeout rather than holding up the
+	// rest: the longest Write waits for room in its queue or under
+	// MaxInFlight, and Close for it to drain.
+	WriteTimeout time.Duration
+	// MaxInFlight, if positive, caps the bytes of fragments queued but not
+	// yet written. Write blocks while a segment won't fit.
+	MaxInFlight int64
+
+	segment []byte          // input not yet encoded
+	ctx     context.Context // if set, checked before and while each segment is encoded
+	length  int64
+	closed  bool
+
+	mu       sync.Mutex
+	failed   map[int]error    // by fragment index
+	queues   []*fragmentQueue // if QueueDepth > 0, once started
+	inFlight int64            // the total queued over queues
+	drained  *sync.Cond       // signalled as queued fragments are written
 }
 
+var errWriterClosed = errors.New("writer already closed")
+
 func getWriters(prefix string, n uint8, perm os.FileMode) ([]io.WriteCloser, error) {
 	var i, j uint8
 	writers := make([]io.WriteCloser, n)
@@ -25,9 +78,9 @@ func getWriters(prefix string, n uint8, perm os.FileMode) ([]io.WriteCloser, err
 		file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 		if err != nil {
 			// Clean up the writers we *did* open
-			for j = 0; i < j; j++ {
+			for j = 0; j < i; j++ {
 				// Ignoring any errors allong the way
-				_ = writers[i].Close()
+				_ = writers[j].Close()
 			}
 			return nil, err
 		}
@@ -36,39 +89,452 @@ func getWriters(prefix string, n uint8, perm os.FileMode) ([]io.WriteCloser, err
 	return writers, nil
 }
 
-func (shim ECWriter) Write(p []byte) (int, error) {
-	frags, err := shim.Backend.Encode(p)
-	if err != nil {
+func (shim *ECWriter) Write(p []byte) (int, error) {
+	if err := shim.check(); err != nil {
 		return 0, err
 	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if shim.SegmentSize <= 0 {
+		if err := shim.writeSegment(p); err != nil {
+			return 0, err
+		}
+		shim.length += int64(len(p))
+		return len(p), nil
+	}
+
+	written := 0
+	for len(p) > 0 {
+		var err error
+		n := shim.SegmentSize - len(shim.segment)
+		if len(shim.segment) == 0 && len(p) >= shim.SegmentSize {
+			// Encode straight from p, rather than copying it in first.
+			n = shim.SegmentSize
+			err = shim.writeSegment(p[:n])
+		} else {
+			if n > len(p) {
+				n = len(p)
+			}
+			shim.segment = append(shim.buffer(), p[:n]...)
+			if len(shim.segment) == shim.SegmentSize {
+				err = shim.flush()
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+		written += n
+		shim.length += int64(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom writes everything read from r until EOF, reading a segment at
+// a time (DefaultSegmentSize bytes if SegmentSize is zero) straight into
+// the writer's buffer. It implements io.ReaderFrom, so io.Copy uses it.
+func (shim *ECWriter) ReadFrom(r io.Reader) (int64, error) {
+	if err := shim.check(); err != nil {
+		return 0, err
+	}
+	size := shim.SegmentSize
+	if size <= 0 {
+		size = DefaultSegmentSize
+	}
+	var total int64
+	for {
+		buf := shim.buffer()
+		if cap(buf) < size {
+			buf = append(make([]byte, 0, size), buf...)
+		}
+		n, err := io.ReadFull(r, buf[len(buf):size])
+		shim.segment = buf[:len(buf)+n]
+		total += int64(n)
+		shim.length += int64(n)
+		if len(shim.segment) == size || (err != nil && shim.SegmentSize <= 0) {
+			if ferr := shim.flush(); ferr != nil {
+				return total, ferr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Length returns the number of bytes written so far, which is the length
+// of the object the archives hold once the writer is closed.
+func (shim *ECWriter) Length() int64 {
+	return shim.length
+}
+
+// buffer returns the segment buffer, allocating it if need be.
+func (shim *ECWriter) buffer() []byte {
+	if shim.segment == nil && shim.SegmentSize > 0 {
+		shim.segment = make([]byte, 0, shim.SegmentSize)
+	}
+	return shim.segment
+}
+
+// flush encodes and writes out any buffered input.
+func (shim *ECWriter) flush() error {
+	if len(shim.segment) == 0 {
+		return nil
+	}
+	err := shim.writeSegment(shim.segment)
+	shim.segment = shim.segment[:0]
+	return err
+}
+
+// writeSegment encodes seg and writes a fragment to each writer.
+func (shim *ECWriter) writeSegment(seg []byte) error {
+	if shim.ctx != nil {
+		if err := shim.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	frags, err := shim.encode(seg)
+	if err != nil {
+		return err
+	}
+	return shim.writeFragments(frags)
+}
+
+// writeFragments writes (or queues) each of frags to the corresponding
+// writer, unless it has already failed, returning an error if quorum is
+// lost. The fragments are recycled once written.
+func (shim *ECWriter) writeFragments(frags [][]byte) error {
+	if shim.QueueDepth > 0 {
+		shim.startQueues()
+		shim.reserve(frags)
+		for i, q := range shim.queues {
+			if !shim.enqueue(i, q, frags[i]) {
+				shim.recycle(frags[i])
+			}
+		}
+		return shim.check()
+	}
 	for i, writer := range shim.Writers {
-		// TODO: check for errors
-		writer.Write(frags[i])
+		if shim.hasFailed(i) {
+			continue
+		}
+		if err := writeFragment(writer, frags[i]); err != nil {
+			shim.fail(i, err)
+		}
+	}
+	shim.recycle(frags...)
+	return shim.check()
+}
+
+// writeFragment writes frag to w, treating a short write as an error.
+func writeFragment(w io.Writer, frag []byte) error {
+	n, err := w.Write(frag)
+	if err == nil && n < len(frag) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+func (shim *ECWriter) hasFailed(index int) bool {
+	shim.mu.Lock()
+	defer shim.mu.Unlock()
+	return shim.failed[index] != nil
+}
+
+// fail records the first error for the archive at index.
+func (shim *ECWriter) fail(index int, err error) {
+	shim.mu.Lock()
+	defer shim.mu.Unlock()
+	shim.failLocked(index, err)
+}
+
+func (shim *ECWriter) failLocked(index int, err error) {
+	if shim.failed[index] != nil {
+		return
+	}
+	if shim.failed == nil {
+		shim.failed = make(map[int]error)
+	}
+	shim.failed[index] = err
+	if shim.queues != nil {
+		// Whatever is still queued will be discarded.
+		q := shim.queues[index]
+		shim.inFlight -= q.queued
+		q.queued = 0
+		shim.drained.Broadcast()
+	}
+}
+
+// check returns an error if the writer is closed, has an invalid Quorum or
+// has lost quorum.
+func (shim *ECWriter) check() error {
+	if shim.closed {
+		return errWriterClosed
+	}
+	if n := len(shim.Writers); shim.Quorum != 0 && (shim.Quorum < shim.Backend.Parameters().K || shim.Quorum > n) {
+		return fmt.Errorf("quorum of %d fragment archives, must be from K=%d to %d: %w",
+			shim.Quorum, shim.Backend.Parameters().K, n, ErrInvalidParams)
+	}
+	if report := shim.report(); report != nil && errors.Is(report, ErrQuorumNotMet) {
+		return report
+	}
+	return nil
+}
+
+// Failures describes the archives that have failed so far, in order. Once
+// the writer is closed, these are the archives missing from the object, or
+// holding only part of it.
+func (shim *ECWriter) Failures() []ArchiveError {
+	if report := shim.report(); report != nil {
+		return report.Failed
+	}
+	return nil
+}
+
+// report describes the archives that have failed, if any.
+func (shim *ECWriter) report() *WriteError {
+	shim.mu.Lock()
+	defer shim.mu.Unlock()
+	if len(shim.failed) == 0 {
+		return nil
+	}
+	report := &WriteError{
+		Healthy: len(shim.Writers) - len(shim.failed),
+		Quorum:  shim.quorum(),
+	}
+	for i := range shim.Writers {
+		if err := shim.failed[i]; err != nil {
+			report.Failed = append(report.Failed, ArchiveError{i, err})
+		}
+	}
+	return report
+}
+
+func (shim *ECWriter) quorum() int {
+	if shim.Quorum != 0 {
+		return shim.Quorum
+	}
+	params := shim.Backend.Parameters()
+	if params.M == 0 {
+		return params.K
+	}
+	return params.K + 1
+}
+
+// encode encodes p into buffers from shim.Buffers, if the backend allows.
+func (shim *ECWriter) encode(p []byte) ([][]byte, error) {
+	if shim.ctx != nil {
+		// Encoding a segment takes a while; give up part way if ctx is
+		// done. The fragments don't come from the pool, but may go to it.
+		return encodeContext(shim.ctx, shim.Backend, p)
+	}
+	coder, ok := shim.Backend.(bufferEncoder)
+	if !ok {
+		return shim.Backend.Encode(p)
+	}
+	pool := shim.buffers()
+	frags := make([][]byte, len(shim.Writers))
+	for i := range frags {
+		frags[i] = pool.Get(0)
+	}
+	if err := coder.EncodeTo(p, frags); err != nil {
+		shim.recycle(frags...)
+		return nil, err
+	}
+	return frags, nil
+}
+
+// recycle returns frags to the pool once they have been written out, if
+// they came from it.
+func (shim *ECWriter) recycle(frags ...[]byte) {
+	if _, ok := shim.Backend.(bufferEncoder); !ok {
+		return
+	}
+	pool := shim.buffers()
+	for _, frag := range frags {
+		pool.Put(frag)
 	}
-	return len(p), nil
 }
 
-func (shim ECWriter) Close() error {
-	var firstErr error
-	for _, writer := range shim.Writers {
-		err := writer.Close()
-		if err != nil && firstErr == nil {
-			firstErr = err
+func (shim *ECWriter) buffers() *BufferPool {
+	if shim.Buffers != nil {
+		return shim.Buffers
+	}
+	return &fragmentBuffers
+}
+
+// Close encodes any buffered input as the final segment, then closes each
+// of Writers. If too few archives were written, whether because of failures
+// writing or closing, the error is a *WriteError; see ECWriter.
+func (shim *ECWriter) Close() error {
+	if shim.closed {
+		return errWriterClosed
+	}
+	err := shim.check()
+	if err == nil {
+		err = shim.flush()
+	}
+	shim.closed = true
+	if shim.queues != nil {
+		shim.closeQueues()
+	} else {
+		for i, writer := range shim.Writers {
+			if cerr := writer.Close(); cerr != nil {
+				shim.fail(i, cerr)
+			}
 		}
 	}
-	return firstErr
+	if _, ok := err.(*WriteError); err != nil && !ok {
+		// encoding failed, so the object is incomplete
+		return err
+	}
+	if report := shim.report(); report != nil && errors.Is(report, ErrQuorumNotMet) {
+		return report
+	}
+	return nil
 }
 
 func (backend *Backend) GetFileWriter(prefix string, perm os.FileMode) (io.WriteCloser, error) {
-	writers, err := getWriters(prefix, uint8(backend.K+backend.M), perm)
+	writer, err := NewFileWriter(backend, prefix, perm)
 	if err != nil {
 		return nil, err
 	}
-	return ECWriter{backend, writers}, nil
+	return writer, nil
+}
+
+// NewFileWriter creates K+M fragment archive files named prefix#0,
+// prefix#1, etc. and returns an ECWriter that writes to them using coder.
+func NewFileWriter(coder Coder, prefix string, perm os.FileMode) (*ECWriter, error) {
+	params := coder.Parameters()
+	writers, err := getWriters(prefix, uint8(params.K+params.M), perm)
+	if err != nil {
+		return nil, err
+	}
+	return &ECWriter{Backend: coder, Writers: writers}, nil
+}
+
+// NewFileWriterContext is NewFileWriter, but the writer gives up once ctx
+// is done: input is encoded in segments of DefaultSegmentSize bytes (or
+// the writer's SegmentSize), with ctx checked before each, and once ctx is
+// done Write and Close return its error and the partially written archives
+// are removed.
+func NewFileWriterContext(ctx context.Context, coder Coder, prefix string, perm os.FileMode) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	params := coder.Parameters()
+	n := params.K + params.M
+	writers, err := getWriters(prefix, uint8(n), perm)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s#%d", prefix, i)
+	}
+	return &contextWriter{
+		ECWriter: ECWriter{
+			Backend:     coder,
+			Writers:     writers,
+			SegmentSize: DefaultSegmentSize,
+			ctx:         ctx,
+		},
+		paths: paths,
+	}, nil
+}
+
+// contextWriter is the ECWriter returned by NewFileWriterContext.
+type contextWriter struct {
+	ECWriter
+	paths   []string // removed if ctx is cancelled
+	aborted bool
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.abortIfDone(nil); err != nil {
+		return 0, err
+	}
+	n, err := w.ECWriter.Write(p)
+	return n, w.abortIfDone(err)
+}
+
+func (w *contextWriter) ReadFrom(r io.Reader) (int64, error) {
+	if err := w.abortIfDone(nil); err != nil {
+		return 0, err
+	}
+	n, err := w.ECWriter.ReadFrom(r)
+	return n, w.abortIfDone(err)
+}
+
+func (w *contextWriter) Close() error {
+	if err := w.abortIfDone(nil); err != nil {
+		return err
+	}
+	return w.ECWriter.Close()
+}
+
+// abortIfDone closes and removes the archives if ctx is done, returning
+// ctx.Err(); otherwise it returns err.
+func (w *contextWriter) abortIfDone(err error) error {
+	ctxErr := w.ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+	if !w.aborted {
+		w.aborted = true
+		_ = w.ECWriter.Close()
+		for _, path := range w.paths {
+			_ = os.Remove(path)
+		}
+	}
+	return ctxErr
+}
+
+// ArchiveSize predicts the size of each fragment archive produced by writing
+// an object of objectSize bytes through an ECWriter with a SegmentSize of
+// segmentSize, such as ec-split sets up with NewFileWriter (or, for
+// DefaultSegmentSize, NewFileWriterContext gives): one fragment per full
+// segment, plus a shorter one for any remainder. GetFileWriter's writer is
+// unbuffered, so only gives this layout if each Write is a segment. Total
+// storage used is K+M times this.
+//
+// Segments are sized for liberasurecode as a C int, so a segmentSize over
+// math.MaxInt32 is rejected.
+func (backend *Backend) ArchiveSize(objectSize, segmentSize int64) (int64, error) {
+	if objectSize < 0 || segmentSize <= 0 || segmentSize > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid object size %d or segment size %d: %w",
+			objectSize, segmentSize, ErrInvalidParams)
+	}
+	fullSegments, remainder := objectSize/segmentSize, objectSize%segmentSize
+	var total int64
+	if fullSegments > 0 {
+		fragSize, err := backend.FragmentSize(int(segmentSize))
+		if err != nil {
+			return 0, err
+		}
+		total += fullSegments * int64(FragmentHeaderSize+fragSize)
+	}
+	if remainder > 0 {
+		fragSize, err := backend.FragmentSize(int(remainder))
+		if err != nil {
+			return 0, err
+		}
+		total += int64(FragmentHeaderSize + fragSize)
+	}
+	return total, nil
 }
 
 func ReadFragment(reader io.Reader) ([]byte, error) {
-	header := make([]byte, C.sizeof_struct_fragment_header_s)
+	return readFragment(reader, nil)
+}
+
+// readFragment is ReadFragment, reading into buf's storage if its capacity
+// allows.
+func readFragment(reader io.Reader, buf []byte) ([]byte, error) {
+	header := resize(buf, FragmentHeaderSize)
 	n, err := io.ReadFull(reader, header)
 	if err != nil {
 		return header[:n], err
@@ -79,8 +545,13 @@ func ReadFragment(reader io.Reader) ([]byte, error) {
 		return header, fmt.Errorf("Metadata checksum failed")
 	}
 
-	frag := make([]byte, len(header)+info.Size)
-	copy(frag, header)
+	frag := header
+	if size := len(header) + info.Size; cap(frag) >= size {
+		frag = frag[:size]
+	} else {
+		frag = make([]byte, size)
+		copy(frag, header)
+	}
 	n, err = io.ReadFull(reader, frag[n:])
 	if err != nil {
 		return frag[:len(header)+n], err
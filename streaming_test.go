@@ -1,11 +1,18 @@
 package erasurecode
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func tempDir() string {
@@ -117,3 +124,501 @@ func TestWriting(t *testing.T) {
 		t.Fatal("Expected error when closing an already-closed writer.")
 	}
 }
+
+func TestArchiveSize(t *testing.T) {
+	base := tempDir()
+	defer os.RemoveAll(base)
+
+	params := validParamGroups[0].params[1]
+	backend, err := InitBackend(params)
+	if err != nil {
+		t.Fatalf("Error creating backend %v: %q", params, err)
+	}
+	defer backend.Close()
+
+	const segmentSize = 1 << 16
+	for _, objectSize := range []int{0, 1, segmentSize - 1, segmentSize, 3*segmentSize + 17} {
+		expected, err := backend.ArchiveSize(int64(objectSize), segmentSize)
+		if err != nil {
+			t.Errorf("ArchiveSize(%v, %v) failed: %v", objectSize, segmentSize, err)
+			continue
+		}
+
+		writer, err := backend.GetFileWriter(base+"test_frags", 0640)
+		if err != nil {
+			t.Fatalf("Error creating writer: %q", err)
+		}
+		data := make([]byte, objectSize)
+		for offset := 0; offset < objectSize; offset += segmentSize {
+			end := offset + segmentSize
+			if end > objectSize {
+				end = objectSize
+			}
+			if _, err := writer.Write(data[offset:end]); err != nil {
+				t.Fatalf("Error writing: %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Error closing writer: %q", err)
+		}
+
+		for index := 0; index < params.K+params.M; index++ {
+			fragPath := fmt.Sprintf("%stest_frags#%d", base, index)
+			info, err := os.Stat(fragPath)
+			if err != nil {
+				t.Errorf("Error stat'ing %v: %v", fragPath, err)
+				continue
+			}
+			if info.Size() != expected {
+				t.Errorf("%v: Expected size %v for %v-byte object, got %v", fragPath, expected, objectSize, info.Size())
+			}
+		}
+	}
+
+	if _, err := backend.ArchiveSize(1, 0); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for zero segment size, got %v", err)
+	}
+	if _, err := backend.ArchiveSize(1<<32, math.MaxInt32+1); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected ErrInvalidParams for segment size over MaxInt32, got %v", err)
+	}
+}
+
+// readArchives decodes the object in the archives prefix#0, prefix#1, etc.,
+// checking each holds one fragment per expected segment size.
+func readArchives(t *testing.T, backend *Backend, prefix string, segments []int) []byte {
+	var fds []*os.File
+	for index := 0; index < backend.K+backend.M; index++ {
+		fd, err := os.Open(fmt.Sprintf("%s#%d", prefix, index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		fds = append(fds, fd)
+	}
+	var object []byte
+	for i, size := range segments {
+		frags := make([][]byte, len(fds))
+		for index, fd := range fds {
+			frag, err := ReadFragment(fd)
+			if err != nil {
+				t.Fatalf("Error reading segment %d of archive %d: %v", i, index, err)
+			}
+			frags[index] = frag
+		}
+		data, err := backend.Decode(frags[backend.M:])
+		if err != nil {
+			t.Fatalf("Error decoding segment %d: %v", i, err)
+		}
+		if len(data) != size {
+			t.Errorf("Expected segment %d to hold %d bytes, got %d", i, size, len(data))
+		}
+		object = append(object, data...)
+	}
+	for index, fd := range fds {
+		if junk, err := ReadFragment(fd); err != io.EOF {
+			t.Errorf("Archive %d: Expected EOF, got %v (and data %v)", index, err, junk)
+		}
+	}
+	return object
+}
+
+func TestSegmentWriter(t *testing.T) {
+	base := tempDir()
+	defer os.RemoveAll(base)
+
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend, err := InitBackend(params)
+	if err != nil {
+		t.Fatalf("Error creating backend %v: %q", params, err)
+	}
+	defer backend.Close()
+
+	object := make([]byte, 4321)
+	rand.Read(object)
+	for _, tc := range []struct {
+		name  string
+		write func(w *ECWriter) error
+	}{
+		{"Write", func(w *ECWriter) error {
+			// Write sizes shouldn't affect the layout.
+			for _, piece := range [][]byte{object[:1], object[1:8], object[8:3008], object[3008:3021], object[3021:]} {
+				if n, err := w.Write(piece); err != nil || n != len(piece) {
+					return fmt.Errorf("wrote %d of %d bytes: %v", n, len(piece), err)
+				}
+			}
+			return nil
+		}},
+		{"ReadFrom", func(w *ECWriter) error {
+			n, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(object)))
+			if n != int64(len(object)) {
+				return fmt.Errorf("copied %d of %d bytes: %v", n, len(object), err)
+			}
+			return err
+		}},
+		{"Both", func(w *ECWriter) error {
+			if _, err := w.Write(object[:10]); err != nil {
+				return err
+			}
+			_, err := w.ReadFrom(bytes.NewReader(object[10:]))
+			return err
+		}},
+		{"Queued", func(w *ECWriter) error {
+			w.QueueDepth = 2
+			_, err := w.ReadFrom(iotest.OneByteReader(bytes.NewReader(object)))
+			return err
+		}},
+	} {
+		writer, err := NewFileWriter(&backend, base+"segments", 0640)
+		if err != nil {
+			t.Fatalf("Error creating writer: %q", err)
+		}
+		writer.SegmentSize = 1000
+		if err := tc.write(writer); err != nil {
+			t.Fatalf("%v: Error writing: %v", tc.name, err)
+		}
+		if writer.Length() != int64(len(object)) {
+			t.Errorf("%v: Expected length %d, got %d", tc.name, len(object), writer.Length())
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("%v: Error closing writer: %q", tc.name, err)
+		}
+
+		expected, err := backend.ArchiveSize(int64(len(object)), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(base + "segments#0"); err != nil {
+			t.Error(err)
+		} else if info.Size() != expected {
+			t.Errorf("%v: Expected archive of %d bytes, got %v", tc.name, expected, info.Size())
+		}
+		got := readArchives(t, &backend, base+"segments", []int{1000, 1000, 1000, 1000, 321})
+		if !bytes.Equal(got, object) {
+			t.Errorf("%v: object did not round-trip", tc.name)
+		}
+	}
+}
+
+// flakyWriter fails every write after the first okWrites, and may write
+// short or fail to close.
+type flakyWriter struct {
+	okWrites int
+	short    bool
+	closeErr error
+	writes   int
+	closed   bool
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes <= w.okWrites {
+		return len(p), nil
+	}
+	if w.short {
+		return len(p) / 2, nil
+	}
+	return 0, errDiskFull
+}
+
+func (w *flakyWriter) Close() error {
+	w.closed = true
+	return w.closeErr
+}
+
+func TestWriterFailures(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend := initGoBackend(t, params)
+	for _, tc := range []struct {
+		name    string
+		writers map[int]flakyWriter
+		quorum  int
+		failed  map[int]error
+		lost    bool // whether quorum is lost
+	}{
+		{"one failure", map[int]flakyWriter{1: {okWrites: 1}}, 0,
+			map[int]error{1: errDiskFull}, false},
+		{"short write", map[int]flakyWriter{5: {okWrites: 2, short: true}}, 0,
+			map[int]error{5: io.ErrShortWrite}, false},
+		{"close failure", map[int]flakyWriter{0: {okWrites: 1 << 30, closeErr: errDiskFull}}, 0,
+			map[int]error{0: errDiskFull}, false},
+		{"two failures", map[int]flakyWriter{0: {okWrites: 1}, 3: {okWrites: 2}}, 0,
+			map[int]error{0: errDiskFull, 3: errDiskFull}, true},
+		{"two failures, quorum 4", map[int]flakyWriter{0: {okWrites: 1}, 3: {okWrites: 2}}, 4,
+			map[int]error{0: errDiskFull, 3: errDiskFull}, false},
+	} {
+		for _, queueDepth := range []int{0, 3} {
+			name := fmt.Sprintf("%s, queue depth %d", tc.name, queueDepth)
+			flaky := make([]*flakyWriter, params.K+params.M)
+			writers := make([]io.WriteCloser, len(flaky))
+			for i := range flaky {
+				w, ok := tc.writers[i]
+				if !ok {
+					w = flakyWriter{okWrites: 1 << 30}
+				}
+				flaky[i] = &w
+				writers[i] = flaky[i]
+			}
+			writer := &ECWriter{Backend: &backend, Writers: writers, Quorum: tc.quorum, QueueDepth: queueDepth}
+
+			var writeErr error
+			for i := 0; i < 4 && writeErr == nil; i++ {
+				_, writeErr = writer.Write(testPatterns[2])
+			}
+			// Queued writes may only fail after Write returns, so a lost
+			// quorum may not show until a later Write, or Close.
+			if writeErr != nil && (!tc.lost || !errors.Is(writeErr, ErrQuorumNotMet)) {
+				t.Errorf("%v: Unexpected error writing: %v", name, writeErr)
+			} else if writeErr == nil && tc.lost && queueDepth == 0 {
+				t.Errorf("%v: Expected ErrQuorumNotMet writing", name)
+			}
+			if writeErr != nil {
+				if _, err := writer.Write(testPatterns[2]); !errors.Is(err, ErrQuorumNotMet) {
+					t.Errorf("%v: Expected writes to keep failing, got %v", name, err)
+				}
+			}
+
+			err := writer.Close()
+			var report *WriteError
+			if !tc.lost && err != nil {
+				t.Errorf("%v: Expected a degraded write to succeed, got %v", name, err)
+			} else if tc.lost && (!errors.As(err, &report) || !errors.Is(err, ErrQuorumNotMet)) {
+				t.Errorf("%v: Expected a WriteError matching ErrQuorumNotMet, got %v", name, err)
+			} else if tc.lost && report.Healthy != len(flaky)-len(tc.failed) {
+				t.Errorf("%v: Unexpected report %+v", name, report)
+			}
+			failures := writer.Failures()
+			if len(failures) != len(tc.failed) {
+				t.Errorf("%v: Unexpected failures %v", name, failures)
+			}
+			for _, failure := range failures {
+				if !errors.Is(failure, tc.failed[failure.Index]) {
+					t.Errorf("%v: Expected #%d to fail with %v, got %v", name, failure.Index, tc.failed[failure.Index], failure.Err)
+				}
+			}
+			for i, w := range flaky {
+				if !w.closed {
+					t.Errorf("%v: writer %d not closed", name, i)
+				}
+				// Once a write fails, the writer is left alone.
+				if w.writes > w.okWrites+1 {
+					t.Errorf("%v: writer %d written to %d times after failing", name, i, w.writes-w.okWrites)
+				}
+			}
+			if err := writer.Close(); err == nil {
+				t.Errorf("%v: Expected error when closing an already-closed writer.", name)
+			}
+		}
+	}
+}
+
+func TestWriterQuorumRange(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend := initGoBackend(t, params)
+	for _, quorum := range []int{-1, 3, 7} {
+		writers := make([]io.WriteCloser, params.K+params.M)
+		for i := range writers {
+			writers[i] = &flakyWriter{okWrites: 1 << 30}
+		}
+		writer := &ECWriter{Backend: &backend, Writers: writers, Quorum: quorum}
+		if _, err := writer.Write(testPatterns[2]); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("Quorum %d: Expected ErrInvalidParams writing, got %v", quorum, err)
+		}
+		if err := writer.Close(); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("Quorum %d: Expected ErrInvalidParams closing, got %v", quorum, err)
+		}
+		for i, w := range writers {
+			if w := w.(*flakyWriter); w.writes != 0 || !w.closed {
+				t.Errorf("Quorum %d: writer %d written %d times (closed: %v)", quorum, i, w.writes, w.closed)
+			}
+		}
+	}
+}
+
+// gatedWriter blocks each write until gate is closed, first signalling
+// started, if set, that the write has begun.
+type gatedWriter struct {
+	gate    chan struct{}
+	started chan<- struct{}
+	written int64 // accessed atomically
+	closed  int32 // likewise
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	if w.started != nil {
+		w.started <- struct{}{}
+	}
+	<-w.gate
+	atomic.AddInt64(&w.written, int64(len(p)))
+	return len(p), nil
+}
+
+func (w *gatedWriter) Close() error {
+	atomic.StoreInt32(&w.closed, 1)
+	return nil
+}
+
+func TestQueuedWriteTimeout(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend := initGoBackend(t, params)
+	stuck := &gatedWriter{gate: make(chan struct{})}
+	writers := make([]io.WriteCloser, params.K+params.M)
+	for i := range writers {
+		writers[i] = &flakyWriter{okWrites: 1 << 30}
+	}
+	writers[2] = stuck
+	writer := &ECWriter{
+		Backend:      &backend,
+		Writers:      writers,
+		QueueDepth:   1,
+		WriteTimeout: 20 * time.Millisecond,
+	}
+	// The stuck writer's queue fills, and it is left behind.
+	for i := 0; i < 5; i++ {
+		if _, err := writer.Write(testPatterns[2]); err != nil {
+			t.Fatalf("Error writing: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Expected quorum to be met, got %v", err)
+	}
+	if failures := writer.Failures(); len(failures) != 1 || failures[0].Index != 2 || !errors.Is(failures[0], ErrWriteTimeout) {
+		t.Errorf("Expected #2 to time out, got %v", failures)
+	}
+	for i, w := range writers {
+		if w, ok := w.(*flakyWriter); ok && (w.writes != 5 || !w.closed) {
+			t.Errorf("Writer %d: Expected 5 writes and close, got %d writes (closed: %v)", i, w.writes, w.closed)
+		}
+	}
+
+	// Once unstuck, it drains what was queued and is closed.
+	close(stuck.gate)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&stuck.closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Lagging writer was never closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	params := Params{Name: "liberasurecode_rs_vand", K: 4, M: 2}
+	backend := initGoBackend(t, params)
+	frags, err := backend.Encode(testPatterns[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := make(chan struct{})
+	started := make(chan struct{}, 3*len(frags))
+	writers := make([]io.WriteCloser, len(frags))
+	for i := range writers {
+		writers[i] = &gatedWriter{gate: gate, started: started}
+	}
+	writer := &ECWriter{
+		Backend:     &backend,
+		Writers:     writers,
+		QueueDepth:  10,
+		MaxInFlight: int64(len(frags) * len(frags[0])),
+	}
+
+	accepted := make(chan struct{}, 3)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if _, err := writer.Write(testPatterns[2]); err != nil {
+				done <- err
+				return
+			}
+			accepted <- struct{}{}
+		}
+		done <- writer.Close()
+	}()
+	// Only one segment fits. Once its fragments are all being written,
+	// nothing more can drain, so the second must still be waiting.
+	<-accepted
+	for range frags {
+		<-started
+	}
+	select {
+	case <-accepted:
+		t.Errorf("Expected the second segment to wait for the first to be written")
+	default:
+	}
+	close(gate)
+	if err := <-done; err != nil {
+		t.Errorf("Error writing: %v", err)
+	}
+	for i, w := range writers {
+		if written := atomic.LoadInt64(&w.(*gatedWriter).written); written != 3*int64(len(frags[i])) {
+			t.Errorf("Writer %d: Expected %d bytes, got %d", i, 3*len(frags[i]), written)
+		}
+	}
+}
+
+// fakeCoder "encodes" by handing each fragment a copy of the input.
+type fakeCoder struct {
+	params Params
+	closed bool
+}
+
+func (c *fakeCoder) Encode(data []byte) ([][]byte, error) {
+	frags := make([][]byte, c.params.K+c.params.M)
+	for i := range frags {
+		frags[i] = append([]byte{byte(i)}, data...)
+	}
+	return frags, nil
+}
+
+func (c *fakeCoder) Decode(frags [][]byte) ([]byte, error) {
+	return frags[0][1:], nil
+}
+
+func (c *fakeCoder) Reconstruct(frags [][]byte, fragIndex int) ([]byte, error) {
+	return append([]byte{byte(fragIndex)}, frags[0][1:]...), nil
+}
+
+func (c *fakeCoder) IsInvalidFragment(frag []byte) bool {
+	return len(frag) == 0
+}
+
+func (c *fakeCoder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeCoder) Parameters() Params {
+	return c.params
+}
+
+func TestFileWriterWithFakeCoder(t *testing.T) {
+	base := tempDir()
+	defer os.RemoveAll(base)
+
+	coder := &fakeCoder{params: Params{Name: "fake", K: 2, M: 2}}
+	writer, err := NewFileWriter(coder, base+"fake_frags", 0640)
+	if err != nil {
+		t.Fatalf("Error creating writer: %q", err)
+	}
+	if _, err := writer.Write([]byte("hello")); err != nil {
+		t.Errorf("Error writing: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Error closing writer: %q", err)
+	}
+	for index := 0; index < 4; index++ {
+		fragPath := fmt.Sprintf("%sfake_frags#%d", base, index)
+		fd, err := os.Open(fragPath)
+		if err != nil {
+			t.Errorf("Error opening %v: %v", fragPath, err)
+			continue
+		}
+		data := make([]byte, 16)
+		n, _ := fd.Read(data)
+		fd.Close()
+		if want := append([]byte{byte(index)}, "hello"...); string(data[:n]) != string(want) {
+			t.Errorf("%v: Expected %q, got %q", fragPath, want, data[:n])
+		}
+	}
+}
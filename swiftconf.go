@@ -0,0 +1,181 @@
+package erasurecode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSegmentSize is Swift's default ec_object_segment_size.
+const DefaultSegmentSize = 1 << 20
+
+// Policy is an erasure-coded storage policy, as configured in a
+// [storage-policy:N] section of Swift's swift.conf.
+type Policy struct {
+	Index      int
+	Name       string
+	Aliases    []string
+	Default    bool
+	Deprecated bool
+	// Params holds ec_type, ec_num_data_fragments and
+	// ec_num_parity_fragments, ready to pass to InitBackend.
+	Params Params
+	// SegmentSize is ec_object_segment_size: objects are encoded this
+	// many bytes at a time.
+	SegmentSize int64
+	// DuplicationFactor is ec_duplication_factor: Swift stores this many
+	// copies of each of the K+M fragments.
+	DuplicationFactor int
+}
+
+// LoadSwiftPolicies reads the erasure-coded storage policies from the
+// swift.conf at path.
+func LoadSwiftPolicies(path string) ([]Policy, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return ParseSwiftPolicies(fd)
+}
+
+// ParseSwiftPolicies reads the erasure-coded storage policies from a
+// swift.conf, sorted by index. Replication policies are skipped.
+func ParseSwiftPolicies(r io.Reader) ([]Policy, error) {
+	sections, err := parseINI(r)
+	if err != nil {
+		return nil, err
+	}
+	var policies []Policy
+	for section, opts := range sections {
+		if !strings.HasPrefix(section, "storage-policy:") {
+			continue
+		}
+		if opts["policy_type"] != "erasure_coding" {
+			continue
+		}
+		policy, err := makePolicy(section, opts)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", section, err)
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Index < policies[j].Index
+	})
+	return policies, nil
+}
+
+// FindPolicy returns the policy with the given name, alias or index.
+func FindPolicy(policies []Policy, nameOrIndex string) (Policy, bool) {
+	for _, policy := range policies {
+		if strconv.Itoa(policy.Index) == nameOrIndex || strings.EqualFold(policy.Name, nameOrIndex) {
+			return policy, true
+		}
+		for _, alias := range policy.Aliases {
+			if strings.EqualFold(alias, nameOrIndex) {
+				return policy, true
+			}
+		}
+	}
+	return Policy{}, false
+}
+
+func makePolicy(section string, opts map[string]string) (Policy, error) {
+	policy := Policy{
+		Name:              opts["name"],
+		Default:           configTrue(opts["default"]),
+		Deprecated:        configTrue(opts["deprecated"]),
+		SegmentSize:       DefaultSegmentSize,
+		DuplicationFactor: 1,
+	}
+	var err error
+	if policy.Index, err = strconv.Atoi(strings.TrimPrefix(section, "storage-policy:")); err != nil {
+		return policy, fmt.Errorf("invalid policy index: %w", ErrInvalidParams)
+	}
+	for _, alias := range strings.Split(opts["aliases"], ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			policy.Aliases = append(policy.Aliases, alias)
+		}
+	}
+
+	ecType, ok := opts["ec_type"]
+	if !ok {
+		return policy, fmt.Errorf("missing ec_type: %w", ErrInvalidParams)
+	}
+	policy.Params.Name, policy.Params.HD = ecTypeToName(ecType)
+	ints := []struct {
+		key      string
+		field    *int
+		required bool
+	}{
+		{"ec_num_data_fragments", &policy.Params.K, true},
+		{"ec_num_parity_fragments", &policy.Params.M, true},
+		{"ec_duplication_factor", &policy.DuplicationFactor, false},
+	}
+	for _, opt := range ints {
+		val, ok := opts[opt.key]
+		if !ok {
+			if opt.required {
+				return policy, fmt.Errorf("missing %s: %w", opt.key, ErrInvalidParams)
+			}
+			continue
+		}
+		if *opt.field, err = strconv.Atoi(val); err != nil {
+			return policy, fmt.Errorf("%s must be an integer, got %q: %w", opt.key, val, ErrInvalidParams)
+		}
+	}
+	if val, ok := opts["ec_object_segment_size"]; ok {
+		if policy.SegmentSize, err = strconv.ParseInt(val, 10, 64); err != nil || policy.SegmentSize <= 0 {
+			return policy, fmt.Errorf("ec_object_segment_size must be a positive integer, got %q: %w",
+				val, ErrInvalidParams)
+		}
+	}
+	if policy.DuplicationFactor < 1 {
+		return policy, fmt.Errorf("ec_duplication_factor must be at least 1: %w", ErrInvalidParams)
+	}
+	return policy, nil
+}
+
+// configTrue follows Swift's config_true_value.
+func configTrue(val string) bool {
+	switch strings.ToLower(val) {
+	case "true", "1", "yes", "on", "t", "y":
+		return true
+	}
+	return false
+}
+
+// parseINI reads just enough of the ConfigParser format for swift.conf:
+// sections, "key = value" or "key: value" options, and comment lines.
+// Option names are lower-cased.
+func parseINI(r io.Reader) (map[string]map[string]string, error) {
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if current = sections[name]; current == nil {
+				current = make(map[string]string)
+				sections[name] = current
+			}
+			continue
+		}
+		sep := strings.IndexAny(line, "=:")
+		if sep < 0 || current == nil {
+			return nil, fmt.Errorf("line %d: cannot parse %q", lineno, line)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:sep]))
+		current[key] = strings.TrimSpace(line[sep+1:])
+	}
+	return sections, scanner.Err()
+}
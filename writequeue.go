@@ -0,0 +1,180 @@
+package erasurecode
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrWriteTimeout is recorded for a fragment archive whose writer lagged
+// more than ECWriter.WriteTimeout behind the others.
+var ErrWriteTimeout = errors.New("fragment write timed out")
+
+// fragmentQueue feeds one of an ECWriter's writers from its own goroutine.
+type fragmentQueue struct {
+	frags  chan []byte
+	done   chan error // the result of closing the writer, once drained
+	queued int64      // bytes queued or being written; guarded by ECWriter.mu
+}
+
+// startQueues starts a goroutine for each writer, if not already running.
+func (shim *ECWriter) startQueues() {
+	if shim.queues != nil {
+		return
+	}
+	shim.drained = sync.NewCond(&shim.mu)
+	queues := make([]*fragmentQueue, len(shim.Writers))
+	for i, writer := range shim.Writers {
+		queues[i] = &fragmentQueue{
+			frags: make(chan []byte, shim.QueueDepth),
+			done:  make(chan error, 1),
+		}
+		go shim.drain(i, writer, queues[i])
+	}
+	shim.mu.Lock()
+	shim.queues = queues
+	shim.mu.Unlock()
+}
+
+// drain writes the fragments queued for writer i until the queue is
+// closed, then closes the writer. Once the writer has failed, anything
+// left in the queue is discarded.
+func (shim *ECWriter) drain(i int, writer io.WriteCloser, q *fragmentQueue) {
+	for frag := range q.frags {
+		if !shim.hasFailed(i) {
+			if err := writeFragment(writer, frag); err != nil {
+				shim.fail(i, err)
+			}
+		}
+		shim.mu.Lock()
+		if shim.failed[i] == nil {
+			// failLocked has already written off the failed ones
+			q.queued -= int64(len(frag))
+			shim.inFlight -= int64(len(frag))
+		}
+		shim.drained.Broadcast()
+		shim.mu.Unlock()
+		shim.recycle(frag)
+	}
+	q.done <- writer.Close()
+}
+
+// reserve waits until frags fit within MaxInFlight. If that takes longer
+// than WriteTimeout, the writer with the most outstanding is failed, and
+// the clock restarts.
+func (shim *ECWriter) reserve(frags [][]byte) {
+	if shim.MaxInFlight <= 0 {
+		return
+	}
+	var size int64
+	for _, frag := range frags {
+		size += int64(len(frag))
+	}
+	shim.mu.Lock()
+	defer shim.mu.Unlock()
+	expired := false
+	var timer *time.Timer
+	for shim.inFlight > 0 && shim.inFlight+size > shim.MaxInFlight {
+		if expired {
+			expired = false
+			if i := shim.slowest(); i >= 0 {
+				shim.failLocked(i, ErrWriteTimeout)
+				continue
+			}
+		}
+		if timer == nil && shim.WriteTimeout > 0 {
+			timer = time.AfterFunc(shim.WriteTimeout, func() {
+				shim.mu.Lock()
+				expired = true
+				timer = nil
+				shim.drained.Broadcast()
+				shim.mu.Unlock()
+			})
+		}
+		shim.drained.Wait()
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// slowest returns the index of the healthy writer with the most bytes
+// outstanding.
+func (shim *ECWriter) slowest() int {
+	slowest := -1
+	for i, q := range shim.queues {
+		if shim.failed[i] == nil && (slowest < 0 || q.queued > shim.queues[slowest].queued) {
+			slowest = i
+		}
+	}
+	return slowest
+}
+
+// enqueue queues frag for writer i, reporting whether it was. If the queue
+// stays full for WriteTimeout, the writer is failed instead.
+func (shim *ECWriter) enqueue(i int, q *fragmentQueue, frag []byte) bool {
+	shim.mu.Lock()
+	if shim.failed[i] != nil {
+		shim.mu.Unlock()
+		return false
+	}
+	q.queued += int64(len(frag))
+	shim.inFlight += int64(len(frag))
+	shim.mu.Unlock()
+
+	select {
+	case q.frags <- frag:
+		return true
+	default:
+	}
+	var expired <-chan time.Time
+	if shim.WriteTimeout > 0 {
+		timer := time.NewTimer(shim.WriteTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case q.frags <- frag:
+		return true
+	case <-expired:
+		shim.fail(i, ErrWriteTimeout)
+		return false
+	}
+}
+
+// closeQueues lets each writer's goroutine finish and close the writer,
+// waiting up to WriteTimeout for them all. Any still busy are failed and
+// left to close their writers in their own time.
+func (shim *ECWriter) closeQueues() {
+	for _, q := range shim.queues {
+		close(q.frags)
+	}
+	var expired <-chan time.Time
+	if shim.WriteTimeout > 0 {
+		timer := time.NewTimer(shim.WriteTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	timedOut := false
+	for i, q := range shim.queues {
+		var err error
+		if timedOut {
+			select {
+			case err = <-q.done:
+			default:
+				err = ErrWriteTimeout
+			}
+		} else {
+			select {
+			case err = <-q.done:
+			case <-expired:
+				timedOut = true
+				err = ErrWriteTimeout
+			}
+		}
+		if err != nil {
+			shim.fail(i, err)
+		}
+	}
+}